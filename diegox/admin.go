@@ -0,0 +1,101 @@
+package diegox
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type AdminState struct {
+	Cells        []Cell           `json:"cells"`
+	Domains      []string         `json:"domains"`
+	TaskRules    []TaskRule       `json:"task_rules"`
+	DockerImages []DockerImage    `json:"docker_images"`
+	Faults       map[string]Fault `json:"faults"`
+	Callbacks    []Callback       `json:"callbacks"`
+	Requests     []AdminRequest   `json:"requests"`
+}
+
+type AdminRequest struct {
+	Endpoint   string    `json:"endpoint"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// AdminState summarises the server's current state for debugging.
+func (s *BBSServer) AdminState() AdminState {
+	state := AdminState{
+		Cells:        s.Cells(),
+		Domains:      s.Domains(),
+		TaskRules:    s.TaskRules(),
+		DockerImages: s.DockerImages(),
+		Faults:       s.Faults(),
+		Callbacks:    s.Callbacks(),
+	}
+
+	for _, req := range s.Requests() {
+		state.Requests = append(state.Requests, AdminRequest{
+			Endpoint:   req.Endpoint,
+			ReceivedAt: req.ReceivedAt,
+		})
+	}
+
+	return state
+}
+
+// AdminHandler serves GET /state, dumping AdminState as JSON, PUT /rules,
+// replacing the task rules with the JSON array in the body, and GET or PUT
+// /snapshot, exporting or importing a Snapshot.
+func (s *BBSServer) AdminHandler() http.Handler {
+	mux := &http.ServeMux{}
+
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.AdminState()); err != nil {
+			s.logger.Error("failed to encode admin state", err)
+		}
+	})
+
+	mux.HandleFunc("/rules", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		defer r.Body.Close()
+
+		var rules []TaskRule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		s.SetTaskRules(rules...)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "application/json")
+			if err := s.ExportState(w); err != nil {
+				s.logger.Error("failed to export state", err)
+			}
+		case "PUT":
+			defer r.Body.Close()
+
+			if err := s.ImportState(r.Body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	return mux
+}
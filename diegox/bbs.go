@@ -1,23 +1,65 @@
 package diegox
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
-	"strings"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
-	"code.cloudfoundry.org/mvcc"
+	"github.com/gogo/protobuf/proto"
 )
 
+const (
+	PingEndpoint         = "/v1/ping"
+	CellsEndpoint        = "/v1/cells/list.r1"
+	UpsertDomainEndpoint = "/v1/domains/upsert"
+	DomainsEndpoint      = "/v1/domains/list"
+	DesireTaskEndpoint   = "/v1/tasks/desire.r2"
+
+	DesireLRPEndpoint                    = "/v1/desired_lrp/desire.r2"
+	UpdateDesiredLRPEndpoint             = "/v1/desired_lrp/update"
+	RemoveDesiredLRPEndpoint             = "/v1/desired_lrp/remove"
+	DesiredLRPsEndpoint                  = "/v1/desired_lrps/list.r2"
+	DesiredLRPByProcessGuidEndpoint      = "/v1/desired_lrps/get_by_process_guid.r2"
+	ActualLRPGroupsEndpoint              = "/v1/actual_lrp_groups/list"
+	ActualLRPGroupsByProcessGuidEndpoint = "/v1/actual_lrp_groups/list_by_process_guid"
+)
+
+const DefaultShutdownTimeout = 5 * time.Second
+
 type BBSServer struct {
-	logger lager.Logger
-	mux    *http.ServeMux
-	server *http.Server
+	logger    lager.Logger
+	tlsConfig *tls.Config
+	mux       *http.ServeMux
+	server    *http.Server
+	listener  net.Listener
+	serveErrs chan error
+	callbacks *callbackQueue
+	recorder  *requestRecorder
+	faults    *faultInjector
+	cells     *cellRegistry
+	domains   *domainStore
+	rules     *taskRules
+	images    *imageCatalogue
+	tasks     *taskRunner
+	lrps      *lrpStore
+	crashes   *crashReporter
+
+	restartCalculator models.RestartCalculator
+
+	// stopMu guards stopped, so that no goroutine is added to wg once
+	// Shutdown has started waiting for it.
+	done    chan struct{}
+	stopMu  sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
 }
 
 func NewBBSServer(opts ...BBSServerOption) *BBSServer {
@@ -27,15 +69,64 @@ func NewBBSServer(opts ...BBSServerOption) *BBSServer {
 	}
 
 	logger := o.logger
+	callbacks := newCallbackQueue(logger, o)
+	recorder := &requestRecorder{}
+	cells := newCellRegistry(o.cells)
+	domains := newDomainStore()
+	rules := &taskRules{}
+	rules.set(o.taskRules)
+	images := &imageCatalogue{}
+	images.set(o.dockerImages)
+	tasks := newTaskRunner(logger, cells, rules, images, callbacks, o)
+	lrps := newLRPStore(logger, cells)
 
 	mux := &http.ServeMux{}
-	mux.HandleFunc("/v1/tasks/desire.r2", desireTaskHandler(logger))
-	mux.HandleFunc("/v1/desired_lrp/remove", nullHandler(logger))
+	mux.HandleFunc(PingEndpoint, pingHandler(logger, recorder))
+	mux.HandleFunc(CellsEndpoint, cellsHandler(logger, recorder, cells))
+	mux.HandleFunc(UpsertDomainEndpoint, upsertDomainHandler(logger, recorder, domains))
+	mux.HandleFunc(DomainsEndpoint, domainsHandler(logger, recorder, domains))
+	mux.HandleFunc(DesireTaskEndpoint, desireTaskHandler(logger, recorder, tasks))
+	mux.HandleFunc(TasksEndpoint, tasksHandler(logger, recorder, tasks))
+	mux.HandleFunc(TaskByGuidEndpoint, taskByGuidHandler(logger, recorder, tasks))
+	mux.HandleFunc(CancelTaskEndpoint, cancelTaskHandler(logger, recorder, tasks))
+	mux.HandleFunc(DesireLRPEndpoint, desireLRPHandler(logger, recorder, lrps))
+	mux.HandleFunc(UpdateDesiredLRPEndpoint, updateDesiredLRPHandler(logger, recorder, lrps))
+	mux.HandleFunc(RemoveDesiredLRPEndpoint, removeDesiredLRPHandler(logger, recorder, lrps))
+	mux.HandleFunc(DesiredLRPsEndpoint, desiredLRPsHandler(logger, recorder, lrps))
+	mux.HandleFunc(DesiredLRPByProcessGuidEndpoint, desiredLRPByProcessGuidHandler(logger, recorder, lrps))
+	mux.HandleFunc(ActualLRPGroupsEndpoint, actualLRPGroupsHandler(logger, recorder, lrps))
+	mux.HandleFunc(ActualLRPGroupsByProcessGuidEndpoint, actualLRPGroupsByProcessGuidHandler(logger, recorder, lrps))
 
-	return &BBSServer{
-		logger: logger,
-		mux:    mux,
+	s := &BBSServer{
+		logger:    logger,
+		tlsConfig: o.tlsConfig,
+		mux:       mux,
+		callbacks: callbacks,
+		recorder:  recorder,
+		faults:    newFaultInjector(logger, mux),
+		cells:     cells,
+		domains:   domains,
+		rules:     rules,
+		images:    images,
+		tasks:     tasks,
+		lrps:      lrps,
+		crashes: &crashReporter{
+			logger: logger.Session("crashes"),
+			client: &http.Client{},
+		},
+		restartCalculator: o.restartCalculator,
+		done:              make(chan struct{}),
 	}
+	s.server = &http.Server{
+		Handler: s.faults,
+	}
+
+	if o.convergenceInterval > 0 {
+		s.wg.Add(1)
+		go s.convergeLoop(o.convergenceInterval)
+	}
+
+	return s
 }
 
 func (s *BBSServer) ListenAndServe(addr string) error {
@@ -48,15 +139,93 @@ func (s *BBSServer) ListenAndServe(addr string) error {
 }
 
 func (s *BBSServer) Serve(listener net.Listener) error {
-	if s.server == nil {
-		s.server = &http.Server{
-			Handler: s.mux,
-		}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
 	}
 
 	return s.server.Serve(listener)
 }
 
+// Start serves on an ephemeral localhost port in the background, like
+// httptest.Server. Use URL and Port to find it and Close to stop it.
+func (s *BBSServer) Start() error {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	s.serveErrs = make(chan error, 1)
+	go func() {
+		err := s.Serve(listener)
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+		s.serveErrs <- err
+	}()
+
+	return nil
+}
+
+func (s *BBSServer) URL() string {
+	scheme := "http"
+	if s.tlsConfig != nil {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://localhost:%d", scheme, s.Port())
+}
+
+func (s *BBSServer) Port() int {
+	if s.listener == nil {
+		return 0
+	}
+
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Shutdown gracefully stops the server and its background workers, waiting
+// for in-flight requests until the context is done. It returns the error the
+// server stopped serving with, if any.
+func (s *BBSServer) Shutdown(ctx context.Context) error {
+	s.stopMu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.done)
+	}
+	s.stopMu.Unlock()
+
+	err := s.server.Shutdown(ctx)
+	s.tasks.stop()
+	s.callbacks.stop()
+	s.wg.Wait()
+
+	if s.serveErrs != nil {
+		serveErr := <-s.serveErrs
+		s.serveErrs = nil
+		if serveErr != nil {
+			return serveErr
+		}
+	}
+
+	return err
+}
+
+// Close shuts the server down, allowing DefaultShutdownTimeout for
+// in-flight requests.
+func (s *BBSServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+	defer cancel()
+
+	return s.Shutdown(ctx)
+}
+
+// Callbacks lists the task completion callbacks the server has queued,
+// optionally limited to the given states.
+func (s *BBSServer) Callbacks(states ...CallbackState) []Callback {
+	return s.callbacks.Callbacks(states...)
+}
+
 type BBSServerOption func(*bbsServerOptions)
 
 func WithLogger(logger lager.Logger) BBSServerOption {
@@ -65,81 +234,204 @@ func WithLogger(logger lager.Logger) BBSServerOption {
 	}
 }
 
+func WithCallbackDeliveryMode(mode CallbackDeliveryMode) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.callbackMode = mode
+	}
+}
+
+func WithCallbackDelay(delay time.Duration) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.callbackDelay = delay
+	}
+}
+
+func WithCallbackRetries(retries int, backoff time.Duration) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.callbackRetries = retries
+		o.callbackBackoff = backoff
+	}
+}
+
+func WithCallbackWorkers(workers int) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.callbackWorkers = workers
+	}
+}
+
+func WithCallbackTLSConfig(config *tls.Config) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.callbackTLSConfig = config
+	}
+}
+
+// WithTLSConfig makes the server terminate TLS, for cloud controllers
+// configured with BBS client certificates.
+func WithTLSConfig(config *tls.Config) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.tlsConfig = config
+	}
+}
+
+func WithTaskRules(rules ...TaskRule) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.taskRules = rules
+	}
+}
+
+// WithDockerImages replaces DefaultDockerImages as the catalogue docker
+// staging looks images up in.
+func WithDockerImages(images ...DockerImage) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.dockerImages = images
+	}
+}
+
+// WithTaskDurations sets how long non-staging tasks stay PENDING and then
+// RUNNING before they complete. Both default to zero.
+func WithTaskDurations(pending, running time.Duration) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.taskPendingDuration = pending
+		o.taskRunDuration = running
+	}
+}
+
+// WithLocalTaskExecution runs the command of non-staging tasks with /bin/sh
+// on the local machine, in a scratch directory with only the task's
+// environment and a minimal PATH, and fails the task if the command exits
+// non-zero or runs for longer than the timeout. Commands are not sandboxed:
+// they run as the test's user with access to the whole filesystem, so only
+// use it with commands you trust.
+func WithLocalTaskExecution(timeout time.Duration) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.taskExecution = true
+		o.taskExecutionTimeout = timeout
+	}
+}
+
+// WithRestartCalculator sets the policy deciding when crashed instances are
+// restarted. It defaults to Diego's models.NewDefaultRestartCalculator.
+func WithRestartCalculator(calc models.RestartCalculator) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.restartCalculator = calc
+	}
+}
+
+// WithConvergenceInterval sets how often crashed instances are restarted. An
+// interval <= 0 disables convergence; call Converge instead.
+func WithConvergenceInterval(interval time.Duration) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.convergenceInterval = interval
+	}
+}
+
+// WithCells replaces the default simulated cell with the given cells.
+func WithCells(cells ...Cell) BBSServerOption {
+	return func(o *bbsServerOptions) {
+		o.cells = cells
+	}
+}
+
 type bbsServerOptions struct {
-	logger lager.Logger
+	logger    lager.Logger
+	tlsConfig *tls.Config
+	cells     []Cell
+	taskRules []TaskRule
+
+	dockerImages []DockerImage
+
+	taskPendingDuration  time.Duration
+	taskRunDuration      time.Duration
+	taskExecution        bool
+	taskExecutionTimeout time.Duration
+
+	restartCalculator   models.RestartCalculator
+	convergenceInterval time.Duration
+
+	callbackMode      CallbackDeliveryMode
+	callbackDelay     time.Duration
+	callbackRetries   int
+	callbackBackoff   time.Duration
+	callbackWorkers   int
+	callbackTLSConfig *tls.Config
 }
 
 func defaultBBSServerOptions() *bbsServerOptions {
 	return &bbsServerOptions{
 		logger: lagertest.NewTestLogger("fake-bbs"),
-	}
-}
+		cells:  []Cell{DefaultCell()},
 
-func nullHandler(logger lager.Logger) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
+		dockerImages: DefaultDockerImages(),
+
+		taskExecutionTimeout: DefaultTaskExecutionTimeout,
+
+		restartCalculator:   models.NewDefaultRestartCalculator(),
+		convergenceInterval: DefaultConvergenceInterval,
+
+		callbackMode:    AsyncCallbackDelivery,
+		callbackRetries: DefaultCallbackRetries,
+		callbackBackoff: DefaultCallbackBackoff,
+		callbackWorkers: DefaultCallbackWorkers,
 	}
 }
 
-func desireTaskHandler(logger lager.Logger) func(http.ResponseWriter, *http.Request) {
+func pingHandler(logger lager.Logger, recorder *requestRecorder) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Debug("started /v1/tasks/desire.r2")
-		defer logger.Debug("finished /v1/tasks/desire.r2")
-
-		defer r.Body.Close()
+		recorder.record(PingEndpoint, nil)
 
-		bits, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			w.WriteHeader(500)
-			logger.Error("failed to read body", err)
-			return
-		}
+		writeResponse(logger, w, &models.PingResponse{
+			Available: true,
+		})
+	}
+}
 
-		req := &models.DesireTaskRequest{}
-		if err = req.Unmarshal(bits); err != nil {
-			w.WriteHeader(500)
-			logger.Error("failed to unmarshal DesireTaskRequest", err)
-			return
-		}
+type protoMessage interface {
+	proto.Message
+	Unmarshal([]byte) error
+}
 
-		body := &taskCallbackRequest{}
-		body.Result.LifecycleMetadata.DockerImage = "alpine"
-		body.Result.LifecycleType = string(mvcc.DockerType)
-		body.Result.ProcessTypes = map[string]string{
-			"docker": "docker run",
-		}
-		body.TaskGUID = req.TaskGuid
-		body.Result.TaskGUID = req.TaskGuid
-
-		b, err := json.Marshal(body)
-		if err != nil {
-			w.WriteHeader(500)
-			logger.Error("failed to marshal taskCallbackRequest", err)
-			return
-		}
+type protoResponse interface {
+	proto.Message
+	Marshal() ([]byte, error)
+}
 
-		callbackURL := strings.Replace(req.TaskDefinition.CompletionCallbackUrl, "https", "http", -1)
-		res, err := http.Post(callbackURL, "application/json", bytes.NewBuffer(b))
+func decodeRequest(logger lager.Logger, w http.ResponseWriter, r *http.Request, req protoMessage) bool {
+	defer r.Body.Close()
 
-		if err != nil || res.StatusCode < 200 || res.StatusCode >= 400 {
-			w.WriteHeader(500)
+	bits, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(500)
+		logger.Error("failed to read body", err)
+		return false
+	}
 
-			var statusCode int
-			if res != nil {
-				statusCode = res.StatusCode
-			}
+	if err = req.Unmarshal(bits); err != nil {
+		w.WriteHeader(500)
+		logger.Error(fmt.Sprintf("failed to unmarshal %T", req), err)
+		return false
+	}
 
-			logger.Error("received unexpected response from callback", err, lager.Data(map[string]interface{}{"statusCode": statusCode}))
-			return
-		}
+	return true
+}
 
-		w.WriteHeader(200)
+func writeResponse(logger lager.Logger, w http.ResponseWriter, res protoResponse) {
+	bits, err := res.Marshal()
+	if err != nil {
+		w.WriteHeader(500)
+		logger.Error(fmt.Sprintf("failed to marshal %T", res), err)
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(200)
+	w.Write(bits)
 }
 
 type taskCallbackRequest struct {
-	TaskGUID string `json:"task_guid"`
-	Result   struct {
+	TaskGUID      string `json:"task_guid"`
+	Failed        bool   `json:"failed"`
+	FailureReason string `json:"failure_reason"`
+	Result        struct {
 		TaskGUID          string            `json:"task_guid"`
 		ExecutionMetadata string            `json:"execution_metadata"`
 		ProcessTypes      map[string]string `json:"process_types"`
@@ -0,0 +1,336 @@
+package diegox
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	DefaultCallbackRetries = 3
+	DefaultCallbackBackoff = 100 * time.Millisecond
+	DefaultCallbackWorkers = 1
+	DefaultCallbackTimeout = 10 * time.Second
+
+	callbackQueueSize = 1024
+)
+
+type CallbackDeliveryMode int
+
+const (
+	// AsyncCallbackDelivery queues the completion callback and answers the
+	// desire request straight away, like Diego does.
+	AsyncCallbackDelivery CallbackDeliveryMode = iota
+
+	// SyncCallbackDelivery delivers the completion callback (including any
+	// retries) before answering the desire request.
+	SyncCallbackDelivery
+)
+
+type CallbackState string
+
+const (
+	CallbackPending   CallbackState = "pending"
+	CallbackDelivered CallbackState = "delivered"
+	CallbackFailed    CallbackState = "failed"
+)
+
+type Callback struct {
+	TaskGUID    string
+	URL         string
+	State       CallbackState
+	Attempts    int
+	StatusCode  int
+	Error       string
+	QueuedAt    time.Time
+	CompletedAt time.Time
+}
+
+type callbackJob struct {
+	callback *Callback
+	body     []byte
+}
+
+type callbackQueue struct {
+	logger lager.Logger
+	client *http.Client
+
+	mode      CallbackDeliveryMode
+	delay     time.Duration
+	retries   int
+	backoff   time.Duration
+	tlsConfig *tls.Config
+
+	jobs   chan *callbackJob
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	wg     sync.WaitGroup
+
+	// sendMu is held for reading while a job is checked and sent, and for
+	// writing while stop drains the queue, so that no job is sent after the
+	// drain.
+	sendMu sync.RWMutex
+
+	mu        sync.RWMutex
+	callbacks []*Callback
+}
+
+func newCallbackQueue(logger lager.Logger, o *bbsServerOptions) *callbackQueue {
+	client := &http.Client{
+		Timeout: DefaultCallbackTimeout,
+	}
+	if o.callbackTLSConfig != nil {
+		client.Transport = &http.Transport{
+			TLSClientConfig: o.callbackTLSConfig,
+		}
+	}
+
+	// ctx is cancelled on stop, so that a cloud controller which never
+	// answers does not hold up the workers.
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q := &callbackQueue{
+		logger:    logger.Session("callbacks"),
+		client:    client,
+		mode:      o.callbackMode,
+		delay:     o.callbackDelay,
+		retries:   o.callbackRetries,
+		backoff:   o.callbackBackoff,
+		tlsConfig: o.callbackTLSConfig,
+		jobs:      make(chan *callbackJob, callbackQueueSize),
+		done:      make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	for i := 0; i < o.callbackWorkers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+// Enqueue records a completion callback for the task and delivers it
+// according to the configured delivery mode.
+func (q *callbackQueue) Enqueue(taskGUID, callbackURL string, body []byte) {
+	callback := &Callback{
+		TaskGUID: taskGUID,
+		URL:      callbackURL,
+		State:    CallbackPending,
+		QueuedAt: time.Now(),
+	}
+
+	q.mu.Lock()
+	q.callbacks = append(q.callbacks, callback)
+	q.mu.Unlock()
+
+	job := &callbackJob{
+		callback: callback,
+		body:     body,
+	}
+
+	if q.mode == SyncCallbackDelivery {
+		q.deliver(job)
+		return
+	}
+
+	q.sendMu.RLock()
+	defer q.sendMu.RUnlock()
+
+	if q.stopped() {
+		q.complete(callback, CallbackFailed, 0, ErrCallbackQueueStopped)
+		return
+	}
+
+	select {
+	case q.jobs <- job:
+	case <-q.done:
+		q.complete(callback, CallbackFailed, 0, ErrCallbackQueueStopped)
+	}
+}
+
+// Callbacks returns a snapshot of every callback the queue has seen,
+// optionally limited to the given states.
+func (q *callbackQueue) Callbacks(states ...CallbackState) []Callback {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	var callbacks []Callback
+	for _, callback := range q.callbacks {
+		if len(states) > 0 && !hasCallbackState(states, callback.State) {
+			continue
+		}
+		callbacks = append(callbacks, *callback)
+	}
+
+	return callbacks
+}
+
+// stop cancels in-flight deliveries and fails the callbacks still queued.
+func (q *callbackQueue) stop() {
+	q.once.Do(func() {
+		close(q.done)
+		q.cancel()
+	})
+	q.wg.Wait()
+
+	q.sendMu.Lock()
+	defer q.sendMu.Unlock()
+
+	for {
+		select {
+		case job := <-q.jobs:
+			q.complete(job.callback, CallbackFailed, 0, ErrCallbackQueueStopped)
+		default:
+			return
+		}
+	}
+}
+
+func (q *callbackQueue) work() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case job := <-q.jobs:
+			if q.stopped() {
+				q.complete(job.callback, CallbackFailed, 0, ErrCallbackQueueStopped)
+				continue
+			}
+			q.deliver(job)
+		case <-q.done:
+			return
+		}
+	}
+}
+
+func (q *callbackQueue) stopped() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *callbackQueue) deliver(job *callbackJob) {
+	callback := job.callback
+	logger := q.logger.Session("deliver", lager.Data{"task-guid": callback.TaskGUID})
+
+	if !q.wait(q.delay) {
+		q.complete(callback, CallbackFailed, 0, ErrCallbackQueueStopped)
+		return
+	}
+
+	target, err := q.resolveURL(callback.URL)
+	if err != nil {
+		logger.Error("failed-to-parse-callback-url", err)
+		q.complete(callback, CallbackFailed, 0, err)
+		return
+	}
+
+	backoff := q.backoff
+	for attempt := 0; ; attempt++ {
+		q.mu.Lock()
+		callback.Attempts++
+		q.mu.Unlock()
+
+		statusCode, err := q.post(target, job.body)
+		if err == nil {
+			logger.Debug("delivered", lager.Data{"attempts": attempt + 1})
+			q.complete(callback, CallbackDelivered, statusCode, nil)
+			return
+		}
+
+		logger.Error("failed-to-deliver", err, lager.Data{"attempt": attempt + 1, "statusCode": statusCode})
+
+		if attempt >= q.retries || !q.wait(backoff) {
+			q.complete(callback, CallbackFailed, statusCode, err)
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (q *callbackQueue) post(target string, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", target, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(q.ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := q.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return res.StatusCode, &ErrUnexpectedCallbackStatusCode{StatusCode: res.StatusCode}
+	}
+
+	return res.StatusCode, nil
+}
+
+// resolveURL downgrades https callbacks to http when no TLS configuration has
+// been provided, since the cloud controller under test usually serves plain
+// HTTP on its TLS port.
+func (q *callbackQueue) resolveURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme == "https" && q.tlsConfig == nil {
+		u.Scheme = "http"
+	}
+
+	return u.String(), nil
+}
+
+func (q *callbackQueue) wait(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-q.done:
+		return false
+	}
+}
+
+func (q *callbackQueue) complete(callback *Callback, state CallbackState, statusCode int, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	callback.State = state
+	callback.StatusCode = statusCode
+	callback.CompletedAt = time.Now()
+	if err != nil {
+		callback.Error = err.Error()
+	}
+}
+
+func hasCallbackState(states []CallbackState, state CallbackState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package diegox
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+)
+
+// Cell describes a simulated Diego cell reported by /v1/cells/list.r1.
+type Cell struct {
+	ID                    string   `json:"id" yaml:"id"`
+	Zone                  string   `json:"zone" yaml:"zone"`
+	MemoryMB              int      `json:"memory_mb" yaml:"memory_mb"`
+	DiskMB                int      `json:"disk_mb" yaml:"disk_mb"`
+	Containers            int      `json:"containers" yaml:"containers"`
+	RootFSProviders       []string `json:"rootfs_providers" yaml:"rootfs_providers"`
+	PreloadedRootFSes     []string `json:"preloaded_rootfses" yaml:"preloaded_rootfses"`
+	PlacementTags         []string `json:"placement_tags" yaml:"placement_tags"`
+	OptionalPlacementTags []string `json:"optional_placement_tags" yaml:"optional_placement_tags"`
+}
+
+func DefaultCell() Cell {
+	return Cell{
+		ID:                "cell-0",
+		Zone:              "z1",
+		MemoryMB:          8192,
+		DiskMB:            65536,
+		Containers:        256,
+		RootFSProviders:   []string{"docker"},
+		PreloadedRootFSes: []string{"cflinuxfs2"},
+	}
+}
+
+func (c Cell) presence() *models.CellPresence {
+	presence := models.NewCellPresence(
+		c.ID,
+		fmt.Sprintf("http://%s.cell.service.cf.internal:1800", c.ID),
+		fmt.Sprintf("https://%s.cell.service.cf.internal:1801", c.ID),
+		c.Zone,
+		models.NewCellCapacity(int32(c.MemoryMB), int32(c.DiskMB), int32(c.Containers)),
+		c.RootFSProviders,
+		c.PreloadedRootFSes,
+		c.PlacementTags,
+		c.OptionalPlacementTags,
+	)
+
+	return &presence
+}
+
+type cellRegistry struct {
+	mu    sync.RWMutex
+	cells map[string]Cell
+}
+
+func newCellRegistry(cells []Cell) *cellRegistry {
+	r := &cellRegistry{}
+	r.set(cells)
+
+	return r
+}
+
+func (r *cellRegistry) set(cells []Cell) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cells = make(map[string]Cell)
+	for _, cell := range cells {
+		r.cells[cell.ID] = cell
+	}
+}
+
+func (r *cellRegistry) add(cell Cell) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cells[cell.ID] = cell
+}
+
+func (r *cellRegistry) remove(cellID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.cells, cellID)
+}
+
+func (r *cellRegistry) list() []Cell {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cells := make([]Cell, 0, len(r.cells))
+	for _, cell := range r.cells {
+		cells = append(cells, cell)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		return cells[i].ID < cells[j].ID
+	})
+
+	return cells
+}
+
+// SetCells replaces every simulated cell.
+func (s *BBSServer) SetCells(cells ...Cell) {
+	s.cells.set(cells)
+}
+
+// AddCell registers a simulated cell, replacing any cell with the same ID.
+func (s *BBSServer) AddCell(cell Cell) {
+	s.cells.add(cell)
+}
+
+func (s *BBSServer) RemoveCell(cellID string) {
+	s.cells.remove(cellID)
+}
+
+func (s *BBSServer) Cells() []Cell {
+	return s.cells.list()
+}
+
+func cellsHandler(logger lager.Logger, recorder *requestRecorder, cells *cellRegistry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder.record(CellsEndpoint, nil)
+
+		res := &models.CellsResponse{}
+		for _, cell := range cells.list() {
+			res.Cells = append(res.Cells, cell.presence())
+		}
+
+		writeResponse(logger, w, res)
+	}
+}
@@ -0,0 +1,240 @@
+package diegox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	uuid "github.com/satori/go.uuid"
+)
+
+const DefaultConvergenceInterval = 30 * time.Second
+
+// crashReporter tells the cloud controller about crashes the way tps-watcher
+// does, so that CC records app.crash events.
+type crashReporter struct {
+	logger lager.Logger
+	client *http.Client
+
+	mu       sync.RWMutex
+	url      string
+	username string
+	password string
+}
+
+type appCrashedRequest struct {
+	Instance        string `json:"instance"`
+	Index           int    `json:"index"`
+	CellID          string `json:"cell_id"`
+	Reason          string `json:"reason"`
+	ExitDescription string `json:"exit_description,omitempty"`
+	CrashCount      int    `json:"crash_count"`
+	CrashTimestamp  int64  `json:"crash_timestamp"`
+}
+
+func (c *crashReporter) configure(url, username, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.url = url
+	c.username = username
+	c.password = password
+}
+
+func (c *crashReporter) report(actual models.ActualLRP) {
+	c.mu.RLock()
+	url, username, password := c.url, c.username, c.password
+	c.mu.RUnlock()
+
+	if url == "" {
+		return
+	}
+
+	logger := c.logger.Session("report", lager.Data{"process-guid": actual.ProcessGuid, "index": actual.Index})
+
+	body, err := json.Marshal(appCrashedRequest{
+		Instance:        actual.InstanceGuid,
+		Index:           int(actual.Index),
+		CellID:          actual.CellId,
+		Reason:          "CRASHED",
+		ExitDescription: actual.CrashReason,
+		CrashCount:      int(actual.CrashCount),
+		CrashTimestamp:  actual.Since,
+	})
+	if err != nil {
+		logger.Error("failed-to-marshal-request", err)
+		return
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/internal/v4/apps/%s/crashed", url, actual.ProcessGuid), bytes.NewBuffer(body))
+	if err != nil {
+		logger.Error("failed-to-build-request", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(username, password)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		logger.Error("failed-to-report-crash", err)
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		logger.Error("failed-to-report-crash", &ErrUnexpectedCallbackStatusCode{StatusCode: res.StatusCode})
+	}
+}
+
+// crash marks the instance CRASHED and restarts it immediately if the
+// restart policy allows, otherwise it waits for convergence.
+func (l *lrpStore) crash(processGuid string, index int, reason string, calc models.RestartCalculator) (models.ActualLRP, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	actuals := l.actual[processGuid]
+	if index < 0 || index >= len(actuals) {
+		return models.ActualLRP{}, models.ErrResourceNotFound
+	}
+
+	before := actuals[index]
+	if before.State != models.ActualLRPStateRunning {
+		return models.ActualLRP{}, models.ErrActualLRPCannotBeCrashed
+	}
+
+	after := *before
+	after.State = models.ActualLRPStateCrashed
+	after.CrashCount++
+	after.CrashReason = reason
+	after.Since = time.Now().UnixNano()
+	actuals[index] = &after
+
+	l.emit(models.NewActualLRPCrashedEvent(before, &after))
+	l.emit(models.NewActualLRPChangedEvent(models.NewRunningActualLRPGroup(before), models.NewRunningActualLRPGroup(&after)))
+
+	if after.ShouldRestartImmediately(calc) {
+		l.restart(processGuid, index)
+	}
+
+	return after, nil
+}
+
+// converge restarts crashed instances whose backoff has elapsed.
+func (l *lrpStore) converge(now time.Time, calc models.RestartCalculator) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for processGuid, actuals := range l.actual {
+		for index, actual := range actuals {
+			if actual.ShouldRestartCrash(now, calc) {
+				l.restart(processGuid, index)
+			}
+		}
+	}
+}
+
+// restart must be called with the lock held. Crash count and reason carry
+// over to the new instance, as they do in Diego.
+func (l *lrpStore) restart(processGuid string, index int) {
+	before := l.actual[processGuid][index]
+
+	after := *before
+	after.State = models.ActualLRPStateRunning
+	after.InstanceGuid = uuid.NewV4().String()
+	after.Since = time.Now().UnixNano()
+	l.actual[processGuid][index] = &after
+
+	l.emit(models.NewActualLRPChangedEvent(models.NewRunningActualLRPGroup(before), models.NewRunningActualLRPGroup(&after)))
+}
+
+func (s *BBSServer) convergeLoop(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.lrps.converge(now, s.restartCalculator)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// CrashInstance crashes the instance at the index of the process, updating
+// its CrashCount and CrashReason, emitting the crash events and reporting the
+// crash to the cloud controller if configured with SetCCInternalAPI.
+func (s *BBSServer) CrashInstance(processGuid string, index int, reason string) error {
+	crashed, err := s.lrps.crash(processGuid, index, reason, s.restartCalculator)
+	if err != nil {
+		return err
+	}
+
+	s.crashes.report(crashed)
+	return nil
+}
+
+// ScheduleCrashes crashes the instance every interval until the returned
+// function is called or the server is closed. It fails if the interval is
+// not positive or the server is shutting down.
+func (s *BBSServer) ScheduleCrashes(processGuid string, index int, every time.Duration, reason string) (func(), error) {
+	if every <= 0 {
+		return nil, ErrInvalidCrashInterval
+	}
+
+	stop := make(chan struct{})
+	var once sync.Once
+
+	s.stopMu.Lock()
+	if s.stopped {
+		s.stopMu.Unlock()
+		return nil, ErrServerStopped
+	}
+	s.wg.Add(1)
+	s.stopMu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.CrashInstance(processGuid, index, reason); err != nil {
+					s.logger.Error("failed to crash instance", err, lager.Data{"process-guid": processGuid, "index": index})
+				}
+			case <-stop:
+				return
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() {
+			close(stop)
+		})
+	}, nil
+}
+
+// Converge runs a convergence pass now, restarting crashed instances whose
+// restart backoff has elapsed.
+func (s *BBSServer) Converge() {
+	s.lrps.converge(time.Now(), s.restartCalculator)
+}
+
+// SetCCInternalAPI points crash reporting at the cloud controller's internal
+// API, authenticating with its internal_api basic auth credentials.
+func (s *BBSServer) SetCCInternalAPI(url, username, password string) {
+	s.crashes.configure(url, username, password)
+}
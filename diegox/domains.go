@@ -0,0 +1,102 @@
+package diegox
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+)
+
+// domainStore tracks domain freshness. A domain upserted with a TTL of zero
+// stays fresh until it is expired explicitly.
+type domainStore struct {
+	mu      sync.RWMutex
+	domains map[string]time.Time
+}
+
+func newDomainStore() *domainStore {
+	return &domainStore{
+		domains: make(map[string]time.Time),
+	}
+}
+
+func (d *domainStore) upsert(domain string, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	d.domains[domain] = expiresAt
+}
+
+func (d *domainStore) expire(domain string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.domains, domain)
+}
+
+func (d *domainStore) fresh() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := time.Now()
+	domains := []string{}
+	for domain, expiresAt := range d.domains {
+		if expiresAt.IsZero() || now.Before(expiresAt) {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+
+	return domains
+}
+
+// UpsertDomain marks the domain fresh for the TTL, as if it had been upserted
+// through /v1/domains/upsert.
+func (s *BBSServer) UpsertDomain(domain string, ttl time.Duration) {
+	s.domains.upsert(domain, ttl)
+}
+
+func (s *BBSServer) ExpireDomain(domain string) {
+	s.domains.expire(domain)
+}
+
+// Domains lists the domains that are currently fresh.
+func (s *BBSServer) Domains() []string {
+	return s.domains.fresh()
+}
+
+func upsertDomainHandler(logger lager.Logger, recorder *requestRecorder, domains *domainStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.UpsertDomainRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(UpsertDomainEndpoint, req)
+
+		res := &models.UpsertDomainResponse{}
+		if err := req.Validate(); err != nil {
+			res.Error = models.ConvertError(err)
+		} else {
+			domains.upsert(req.Domain, time.Duration(req.Ttl)*time.Second)
+		}
+
+		writeResponse(logger, w, res)
+	}
+}
+
+func domainsHandler(logger lager.Logger, recorder *requestRecorder, domains *domainStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder.record(DomainsEndpoint, nil)
+
+		writeResponse(logger, w, &models.DomainsResponse{
+			Domains: domains.fresh(),
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package diegox
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrCallbackQueueStopped = errors.New("callback queue stopped")
+	ErrServerStopped        = errors.New("server stopped")
+	ErrInvalidCrashInterval = errors.New("crash interval must be positive")
+)
+
+type ErrUnexpectedCallbackStatusCode struct {
+	StatusCode int
+}
+
+func (e *ErrUnexpectedCallbackStatusCode) Error() string {
+	return fmt.Sprintf("unexpected callback status code (%d)", e.StatusCode)
+}
+
+type ErrInvalidSnapshot struct {
+	Reason string
+}
+
+func (e *ErrInvalidSnapshot) Error() string {
+	return fmt.Sprintf("invalid snapshot: %s", e.Reason)
+}
+
+type ErrDockerImageNotFound struct {
+	Ref string
+}
+
+func (e *ErrDockerImageNotFound) Error() string {
+	return fmt.Sprintf("failed to fetch metadata for docker image %s: manifest unknown", e.Ref)
+}
+
+type ErrDockerImageUnauthorized struct {
+	Ref string
+}
+
+func (e *ErrDockerImageUnauthorized) Error() string {
+	return fmt.Sprintf("failed to fetch metadata for docker image %s: unauthorized", e.Ref)
+}
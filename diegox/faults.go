@@ -0,0 +1,239 @@
+package diegox
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+)
+
+// AllEndpoints can be passed in place of an endpoint to apply a fault to
+// every request the server receives.
+const AllEndpoints = "*"
+
+// Fault describes how the server misbehaves for an endpoint. Latency is
+// applied first; the remaining fields are checked in order and the first one
+// set decides the response.
+type Fault struct {
+	// Latency delays the response by a fixed duration, plus a random
+	// duration in [0, LatencyJitter).
+	Latency       time.Duration `json:"latency"`
+	LatencyJitter time.Duration `json:"latency_jitter"`
+
+	// ResetConnection drops the TCP connection without responding.
+	ResetConnection bool `json:"reset_connection"`
+
+	// StatusCode responds with a bare HTTP status code, e.g. 503.
+	StatusCode int `json:"status_code"`
+
+	// BBSError responds with a 200 carrying the models.Error in the
+	// response envelope, the way BBS reports failures. Pings, whose
+	// response has no error, report BBS unavailable instead.
+	BBSError *models.Error `json:"bbs_error"`
+
+	// Times limits the fault to the next n matching requests. Zero means
+	// the fault applies until it is cleared.
+	Times int `json:"times"`
+}
+
+type faultInjector struct {
+	logger lager.Logger
+	next   http.Handler
+
+	mu        sync.Mutex
+	faults    map[string]*Fault
+	downUntil map[string]time.Time
+}
+
+func newFaultInjector(logger lager.Logger, next http.Handler) *faultInjector {
+	return &faultInjector{
+		logger:    logger.Session("faults"),
+		next:      next,
+		faults:    make(map[string]*Fault),
+		downUntil: make(map[string]time.Time),
+	}
+}
+
+func (f *faultInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := r.URL.Path
+	logger := f.logger.Session("serve", lager.Data{"endpoint": endpoint})
+
+	if f.isDown(endpoint) {
+		logger.Debug("down")
+		resetConnection(logger, w)
+		return
+	}
+
+	fault, ok := f.take(endpoint)
+	if !ok {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+
+	latency := fault.Latency
+	if fault.LatencyJitter > 0 {
+		latency += time.Duration(rand.Int63n(int64(fault.LatencyJitter)))
+	}
+	time.Sleep(latency)
+
+	switch {
+	case fault.ResetConnection:
+		logger.Debug("resetting-connection")
+		resetConnection(logger, w)
+	case fault.StatusCode != 0:
+		logger.Debug("responding-with-status-code", lager.Data{"statusCode": fault.StatusCode})
+		w.WriteHeader(fault.StatusCode)
+	case fault.BBSError != nil:
+		logger.Debug("responding-with-bbs-error", lager.Data{"error": fault.BBSError.Error()})
+		writeBBSError(logger, w, endpoint, fault.BBSError)
+	default:
+		f.next.ServeHTTP(w, r)
+	}
+}
+
+func (f *faultInjector) inject(endpoint string, fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.faults[endpoint] = &fault
+}
+
+func (f *faultInjector) clear(endpoint string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.faults, endpoint)
+	delete(f.downUntil, endpoint)
+}
+
+func (f *faultInjector) clearAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.faults = make(map[string]*Fault)
+	f.downUntil = make(map[string]time.Time)
+}
+
+func (f *faultInjector) list() map[string]Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	faults := make(map[string]Fault, len(f.faults))
+	for endpoint, fault := range f.faults {
+		faults[endpoint] = *fault
+	}
+
+	return faults
+}
+
+func (f *faultInjector) takeDown(endpoint string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.downUntil[endpoint] = time.Now().Add(d)
+}
+
+func (f *faultInjector) isDown(endpoint string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range []string{endpoint, AllEndpoints} {
+		if until, ok := f.downUntil[key]; ok {
+			if now.Before(until) {
+				return true
+			}
+			delete(f.downUntil, key)
+		}
+	}
+
+	return false
+}
+
+func (f *faultInjector) take(endpoint string) (Fault, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, key := range []string{endpoint, AllEndpoints} {
+		fault, ok := f.faults[key]
+		if !ok {
+			continue
+		}
+
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				delete(f.faults, key)
+			}
+		}
+
+		return *fault, true
+	}
+
+	return Fault{}, false
+}
+
+func resetConnection(logger lager.Logger, w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		logger.Error("failed-to-hijack-connection", err)
+		return
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// writeBBSError encodes the error in a TaskLifecycleResponse. Every BBS
+// response message but PingResponse carries its error as field 1, so the
+// encoding is the same for every other endpoint. PingResponse has no error,
+// so a ping answers that BBS is unavailable instead.
+func writeBBSError(logger lager.Logger, w http.ResponseWriter, endpoint string, bbsErr *models.Error) {
+	if endpoint == PingEndpoint {
+		writeResponse(logger, w, &models.PingResponse{
+			Available: false,
+		})
+		return
+	}
+
+	writeResponse(logger, w, &models.TaskLifecycleResponse{
+		Error: bbsErr,
+	})
+}
+
+// InjectFault makes the server misbehave for the endpoint (or AllEndpoints)
+// until the fault is cleared or has been applied Fault.Times times.
+func (s *BBSServer) InjectFault(endpoint string, fault Fault) {
+	s.faults.inject(endpoint, fault)
+}
+
+// TakeDown resets every connection to the endpoint (or AllEndpoints) for the
+// given duration.
+func (s *BBSServer) TakeDown(endpoint string, d time.Duration) {
+	s.faults.takeDown(endpoint, d)
+}
+
+// Faults lists the faults currently injected, keyed by endpoint.
+func (s *BBSServer) Faults() map[string]Fault {
+	return s.faults.list()
+}
+
+func (s *BBSServer) ClearFault(endpoint string) {
+	s.faults.clear(endpoint)
+}
+
+func (s *BBSServer) ClearFaults() {
+	s.faults.clearAll()
+}
@@ -0,0 +1,286 @@
+package diegox
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/mvcc"
+)
+
+// DockerImage is the metadata a docker registry would report for Ref. Staging
+// a docker package that references an image missing from the catalogue
+// fails, as it would against a real registry.
+type DockerImage struct {
+	Ref          string   `json:"ref" yaml:"ref"`
+	ExposedPorts []uint32 `json:"exposed_ports" yaml:"exposed_ports"`
+	User         string   `json:"user" yaml:"user"`
+	Entrypoint   []string `json:"entrypoint" yaml:"entrypoint"`
+	Cmd          []string `json:"cmd" yaml:"cmd"`
+	WorkDir      string   `json:"workdir" yaml:"workdir"`
+
+	// Username and Password, when set, make the image private: staging only
+	// succeeds if the package carries the same registry credentials.
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+}
+
+// DefaultDockerImages is the catalogue a BBSServer starts with.
+func DefaultDockerImages() []DockerImage {
+	return []DockerImage{
+		{
+			Ref:  "alpine",
+			User: "root",
+			Cmd:  []string{"/bin/sh"},
+		},
+		{
+			Ref:          "cloudfoundry/diego-docker-app",
+			ExposedPorts: []uint32{8080},
+			User:         "root",
+			Entrypoint:   []string{"/myapp/dockerapp"},
+			WorkDir:      "/myapp",
+		},
+	}
+}
+
+func (i DockerImage) startCommand() string {
+	return strings.Join(append(append([]string(nil), i.Entrypoint...), i.Cmd...), " ")
+}
+
+// executionMetadata is what the docker app lifecycle builder reports, and
+// what the cloud controller reads exposed ports from.
+func (i DockerImage) executionMetadata() (string, error) {
+	type port struct {
+		Port     uint32 `json:"Port"`
+		Protocol string `json:"Protocol"`
+	}
+
+	metadata := struct {
+		Cmd        []string `json:"cmd,omitempty"`
+		Entrypoint []string `json:"entrypoint,omitempty"`
+		WorkDir    string   `json:"workdir,omitempty"`
+		Ports      []port   `json:"ports,omitempty"`
+		User       string   `json:"user,omitempty"`
+	}{
+		Cmd:        i.Cmd,
+		Entrypoint: i.Entrypoint,
+		WorkDir:    i.WorkDir,
+		User:       i.User,
+	}
+	for _, p := range i.ExposedPorts {
+		metadata.Ports = append(metadata.Ports, port{Port: p, Protocol: "tcp"})
+	}
+
+	bits, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bits), nil
+}
+
+// normalizeDockerRef maps equivalent references to the same key, so that
+// "alpine", "alpine:latest" and "docker.io/library/alpine:latest" all find
+// the same catalogue entry.
+func normalizeDockerRef(ref string) string {
+	ref = strings.TrimPrefix(ref, "docker.io/")
+	ref = strings.TrimPrefix(ref, "index.docker.io/")
+	ref = strings.TrimPrefix(ref, "library/")
+
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	if i := strings.LastIndex(ref, ":"); i < 0 || strings.Contains(ref[i:], "/") {
+		ref += ":latest"
+	}
+
+	return ref
+}
+
+type imageCatalogue struct {
+	mu     sync.RWMutex
+	images []DockerImage
+}
+
+func (c *imageCatalogue) set(images []DockerImage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.images = append([]DockerImage(nil), images...)
+}
+
+func (c *imageCatalogue) add(image DockerImage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ref := normalizeDockerRef(image.Ref)
+	for i, existing := range c.images {
+		if normalizeDockerRef(existing.Ref) == ref {
+			c.images[i] = image
+			return
+		}
+	}
+	c.images = append(c.images, image)
+}
+
+func (c *imageCatalogue) remove(ref string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ref = normalizeDockerRef(ref)
+	for i, existing := range c.images {
+		if normalizeDockerRef(existing.Ref) == ref {
+			c.images = append(c.images[:i], c.images[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (c *imageCatalogue) list() []DockerImage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]DockerImage(nil), c.images...)
+}
+
+// fetch looks the image up the way the docker builder would, checking the
+// registry credentials of private images.
+func (c *imageCatalogue) fetch(ref, username, password string) (DockerImage, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	normalized := normalizeDockerRef(ref)
+	for _, image := range c.images {
+		if normalizeDockerRef(image.Ref) != normalized {
+			continue
+		}
+		if image.Username != "" && (image.Username != username || image.Password != password) {
+			return DockerImage{}, &ErrDockerImageUnauthorized{Ref: ref}
+		}
+		return image, nil
+	}
+
+	return DockerImage{}, &ErrDockerImageNotFound{Ref: ref}
+}
+
+// dockerStagingArgs is the image reference and registry credentials CC passes
+// to the docker app lifecycle builder.
+type dockerStagingArgs struct {
+	ref      string
+	username string
+	password string
+}
+
+// findDockerStagingArgs walks the task's actions for the builder invocation.
+// It returns false for tasks that do not stage a docker package.
+func findDockerStagingArgs(action *models.Action) (dockerStagingArgs, bool) {
+	var args dockerStagingArgs
+	found := findRunAction(action, func(run *models.RunAction) bool {
+		var ok bool
+		args, ok = parseDockerStagingArgs(run.Args)
+		return ok
+	}) != nil
+
+	return args, found
+}
+
+// findRunAction returns the first run action, depth first, that match accepts.
+func findRunAction(action *models.Action, match func(*models.RunAction) bool) *models.RunAction {
+	if action == nil {
+		return nil
+	}
+
+	var children []*models.Action
+	switch a := action.GetValue().(type) {
+	case *models.RunAction:
+		if match(a) {
+			return a
+		}
+	case *models.TimeoutAction:
+		children = []*models.Action{a.Action}
+	case *models.EmitProgressAction:
+		children = []*models.Action{a.Action}
+	case *models.TryAction:
+		children = []*models.Action{a.Action}
+	case *models.SerialAction:
+		children = a.Actions
+	case *models.ParallelAction:
+		children = a.Actions
+	case *models.CodependentAction:
+		children = a.Actions
+	}
+
+	for _, child := range children {
+		if run := findRunAction(child, match); run != nil {
+			return run
+		}
+	}
+
+	return nil
+}
+
+func parseDockerStagingArgs(argv []string) (dockerStagingArgs, bool) {
+	var args dockerStagingArgs
+	found := false
+
+	for i := 0; i+1 < len(argv); i++ {
+		switch argv[i] {
+		case "-dockerRef":
+			args.ref = argv[i+1]
+			found = true
+		case "-dockerUser":
+			args.username = argv[i+1]
+		case "-dockerPassword":
+			args.password = argv[i+1]
+		default:
+			continue
+		}
+		i++
+	}
+
+	return args, found
+}
+
+// stageDockerImage fills in the staging result the docker app lifecycle
+// builder would produce for the image.
+func stageDockerImage(body *taskCallbackRequest, images *imageCatalogue, args dockerStagingArgs) error {
+	image, err := images.fetch(args.ref, args.username, args.password)
+	if err != nil {
+		return err
+	}
+
+	executionMetadata, err := image.executionMetadata()
+	if err != nil {
+		return err
+	}
+
+	body.Result.LifecycleType = string(mvcc.DockerType)
+	body.Result.LifecycleMetadata.DockerImage = args.ref
+	body.Result.ExecutionMetadata = executionMetadata
+	body.Result.ProcessTypes = map[string]string{
+		"web": image.startCommand(),
+	}
+
+	return nil
+}
+
+// SetDockerImages replaces the image catalogue.
+func (s *BBSServer) SetDockerImages(images ...DockerImage) {
+	s.images.set(images)
+}
+
+// AddDockerImage adds the image to the catalogue, replacing any entry with
+// an equivalent reference.
+func (s *BBSServer) AddDockerImage(image DockerImage) {
+	s.images.add(image)
+}
+
+func (s *BBSServer) RemoveDockerImage(ref string) bool {
+	return s.images.remove(ref)
+}
+
+func (s *BBSServer) DockerImages() []DockerImage {
+	return s.images.list()
+}
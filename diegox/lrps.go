@@ -0,0 +1,354 @@
+package diegox
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	uuid "github.com/satori/go.uuid"
+)
+
+// lrpStore holds desired LRPs and their simulated actual LRPs. Every desired
+// instance is placed on a cell and reported RUNNING straight away.
+type lrpStore struct {
+	logger lager.Logger
+	cells  *cellRegistry
+
+	mu      sync.Mutex
+	desired map[string]*models.DesiredLRP
+	actual  map[string][]*models.ActualLRP
+	events  []models.Event
+}
+
+func newLRPStore(logger lager.Logger, cells *cellRegistry) *lrpStore {
+	return &lrpStore{
+		logger:  logger.Session("lrps"),
+		cells:   cells,
+		desired: make(map[string]*models.DesiredLRP),
+		actual:  make(map[string][]*models.ActualLRP),
+	}
+}
+
+func (l *lrpStore) desire(lrp *models.DesiredLRP) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.desired[lrp.ProcessGuid]; ok {
+		return models.ErrResourceExists
+	}
+
+	l.desired[lrp.ProcessGuid] = lrp
+	l.emit(models.NewDesiredLRPCreatedEvent(lrp))
+	l.scale(lrp)
+
+	return nil
+}
+
+func (l *lrpStore) update(processGuid string, update *models.DesiredLRPUpdate) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	before, ok := l.desired[processGuid]
+	if !ok {
+		return models.ErrResourceNotFound
+	}
+
+	after := before.Copy()
+	if update.Instances != nil {
+		after.Instances = *update.Instances
+	}
+	if update.Routes != nil {
+		after.Routes = update.Routes
+	}
+	if update.Annotation != nil {
+		after.Annotation = *update.Annotation
+	}
+
+	l.desired[processGuid] = after
+	l.emit(models.NewDesiredLRPChangedEvent(before, after))
+	l.scale(after)
+
+	return nil
+}
+
+func (l *lrpStore) remove(processGuid string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lrp, ok := l.desired[processGuid]
+	if !ok {
+		return models.ErrResourceNotFound
+	}
+
+	for _, actual := range l.actual[processGuid] {
+		l.emit(models.NewActualLRPRemovedEvent(models.NewRunningActualLRPGroup(actual)))
+	}
+
+	delete(l.actual, processGuid)
+	delete(l.desired, processGuid)
+	l.emit(models.NewDesiredLRPRemovedEvent(lrp))
+
+	return nil
+}
+
+// scale starts or stops actual LRPs until they match the desired instance
+// count. It must be called with the lock held.
+func (l *lrpStore) scale(lrp *models.DesiredLRP) {
+	actuals := l.actual[lrp.ProcessGuid]
+
+	for i := int32(len(actuals)); i < lrp.Instances; i++ {
+		actual := l.newRunningActualLRP(lrp, i)
+		actuals = append(actuals, actual)
+		l.emit(models.NewActualLRPCreatedEvent(models.NewRunningActualLRPGroup(actual)))
+	}
+
+	for int32(len(actuals)) > lrp.Instances {
+		actual := actuals[len(actuals)-1]
+		actuals = actuals[:len(actuals)-1]
+		l.emit(models.NewActualLRPRemovedEvent(models.NewRunningActualLRPGroup(actual)))
+	}
+
+	l.actual[lrp.ProcessGuid] = actuals
+}
+
+func (l *lrpStore) newRunningActualLRP(lrp *models.DesiredLRP, index int32) *models.ActualLRP {
+	cellID := DefaultCell().ID
+	if cells := l.cells.list(); len(cells) > 0 {
+		cellID = cells[int(index)%len(cells)].ID
+	}
+
+	return models.NewRunningActualLRP(
+		models.NewActualLRPKey(lrp.ProcessGuid, index, lrp.Domain),
+		models.NewActualLRPInstanceKey(uuid.NewV4().String(), cellID),
+		models.NewActualLRPNetInfo("127.0.0.1", fmt.Sprintf("10.255.0.%d", index+1), models.NewPortMapping(uint32(61000+index), 8080)),
+		time.Now().UnixNano(),
+	)
+}
+
+func (l *lrpStore) desiredLRP(processGuid string) (*models.DesiredLRP, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lrp, ok := l.desired[processGuid]
+	return lrp, ok
+}
+
+func (l *lrpStore) desiredLRPs(domain string, processGuids []string) []*models.DesiredLRP {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var lrps []*models.DesiredLRP
+	for _, lrp := range l.desired {
+		if domain != "" && lrp.Domain != domain {
+			continue
+		}
+		if len(processGuids) > 0 && !containsString(processGuids, lrp.ProcessGuid) {
+			continue
+		}
+		lrps = append(lrps, lrp)
+	}
+	sort.Slice(lrps, func(i, j int) bool {
+		return lrps[i].ProcessGuid < lrps[j].ProcessGuid
+	})
+
+	return lrps
+}
+
+func (l *lrpStore) actualLRPGroups(processGuid string) []*models.ActualLRPGroup {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var groups []*models.ActualLRPGroup
+	for _, actual := range l.actual[processGuid] {
+		copied := *actual
+		groups = append(groups, models.NewRunningActualLRPGroup(&copied))
+	}
+
+	return groups
+}
+
+func (l *lrpStore) allActualLRPGroups(domain, cellID string) []*models.ActualLRPGroup {
+	l.mu.Lock()
+	processGuids := make([]string, 0, len(l.actual))
+	for processGuid := range l.actual {
+		processGuids = append(processGuids, processGuid)
+	}
+	l.mu.Unlock()
+	sort.Strings(processGuids)
+
+	var groups []*models.ActualLRPGroup
+	for _, processGuid := range processGuids {
+		for _, group := range l.actualLRPGroups(processGuid) {
+			if domain != "" && group.Instance.Domain != domain {
+				continue
+			}
+			if cellID != "" && group.Instance.CellId != cellID {
+				continue
+			}
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// emit must be called with the lock held.
+func (l *lrpStore) emit(event models.Event) {
+	l.logger.Debug("emit", lager.Data{"type": event.EventType(), "key": event.Key()})
+	l.events = append(l.events, event)
+}
+
+func (l *lrpStore) listEvents(eventTypes ...string) []models.Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var events []models.Event
+	for _, event := range l.events {
+		if len(eventTypes) > 0 && !containsString(eventTypes, event.EventType()) {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func (s *BBSServer) DesiredLRPs() []*models.DesiredLRP {
+	return s.lrps.desiredLRPs("", nil)
+}
+
+// ActualLRPs returns a copy of the actual LRPs of the process, by index.
+func (s *BBSServer) ActualLRPs(processGuid string) []*models.ActualLRP {
+	var actuals []*models.ActualLRP
+	for _, group := range s.lrps.actualLRPGroups(processGuid) {
+		actuals = append(actuals, group.Instance)
+	}
+
+	return actuals
+}
+
+// LRPEvents lists the LRP events the server has emitted, optionally limited to
+// the given event types (e.g. models.EventTypeActualLRPCrashed).
+func (s *BBSServer) LRPEvents(eventTypes ...string) []models.Event {
+	return s.lrps.listEvents(eventTypes...)
+}
+
+func desireLRPHandler(logger lager.Logger, recorder *requestRecorder, lrps *lrpStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.DesireLRPRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(DesireLRPEndpoint, req)
+
+		res := &models.DesiredLRPLifecycleResponse{}
+		if err := req.Validate(); err != nil {
+			res.Error = models.ConvertError(err)
+		} else if err = lrps.desire(req.DesiredLrp); err != nil {
+			res.Error = models.ConvertError(err)
+		}
+
+		writeResponse(logger, w, res)
+	}
+}
+
+func updateDesiredLRPHandler(logger lager.Logger, recorder *requestRecorder, lrps *lrpStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.UpdateDesiredLRPRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(UpdateDesiredLRPEndpoint, req)
+
+		res := &models.DesiredLRPLifecycleResponse{}
+		if err := req.Validate(); err != nil {
+			res.Error = models.ConvertError(err)
+		} else if err = lrps.update(req.ProcessGuid, req.Update); err != nil {
+			res.Error = models.ConvertError(err)
+		}
+
+		writeResponse(logger, w, res)
+	}
+}
+
+func removeDesiredLRPHandler(logger lager.Logger, recorder *requestRecorder, lrps *lrpStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.RemoveDesiredLRPRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(RemoveDesiredLRPEndpoint, req)
+
+		res := &models.DesiredLRPLifecycleResponse{}
+		if err := lrps.remove(req.ProcessGuid); err != nil && err != models.ErrResourceNotFound {
+			res.Error = models.ConvertError(err)
+		}
+
+		writeResponse(logger, w, res)
+	}
+}
+
+func desiredLRPsHandler(logger lager.Logger, recorder *requestRecorder, lrps *lrpStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.DesiredLRPsRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(DesiredLRPsEndpoint, req)
+
+		writeResponse(logger, w, &models.DesiredLRPsResponse{
+			DesiredLrps: lrps.desiredLRPs(req.Domain, req.ProcessGuids),
+		})
+	}
+}
+
+func desiredLRPByProcessGuidHandler(logger lager.Logger, recorder *requestRecorder, lrps *lrpStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.DesiredLRPByProcessGuidRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(DesiredLRPByProcessGuidEndpoint, req)
+
+		res := &models.DesiredLRPResponse{}
+		if lrp, ok := lrps.desiredLRP(req.ProcessGuid); ok {
+			res.DesiredLrp = lrp
+		} else {
+			res.Error = models.ErrResourceNotFound
+		}
+
+		writeResponse(logger, w, res)
+	}
+}
+
+func actualLRPGroupsHandler(logger lager.Logger, recorder *requestRecorder, lrps *lrpStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.ActualLRPGroupsRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(ActualLRPGroupsEndpoint, req)
+
+		writeResponse(logger, w, &models.ActualLRPGroupsResponse{
+			ActualLrpGroups: lrps.allActualLRPGroups(req.Domain, req.CellId),
+		})
+	}
+}
+
+func actualLRPGroupsByProcessGuidHandler(logger lager.Logger, recorder *requestRecorder, lrps *lrpStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.ActualLRPGroupsByProcessGuidRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(ActualLRPGroupsByProcessGuidEndpoint, req)
+
+		writeResponse(logger, w, &models.ActualLRPGroupsResponse{
+			ActualLrpGroups: lrps.actualLRPGroups(req.ProcessGuid),
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/mvcc/diegox"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// config is read from YAML, or JSON since it is a subset of YAML.
+type config struct {
+	ListenAddress string `yaml:"listen_address"`
+	AdminAddress  string `yaml:"admin_address"`
+	LogLevel      string `yaml:"log_level"`
+	StateFile     string `yaml:"state_file"`
+
+	TLS struct {
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+		CAFile   string `yaml:"ca_file"`
+	} `yaml:"tls"`
+
+	TaskRules    []diegox.TaskRule      `yaml:"task_rules"`
+	Cells        []diegox.Cell          `yaml:"cells"`
+	DockerImages []diegox.DockerImage   `yaml:"docker_images"`
+	Faults       map[string]faultConfig `yaml:"faults"`
+}
+
+type faultConfig struct {
+	Latency         string `yaml:"latency"`
+	LatencyJitter   string `yaml:"latency_jitter"`
+	ResetConnection bool   `yaml:"reset_connection"`
+	StatusCode      int    `yaml:"status_code"`
+	BBSError        string `yaml:"bbs_error"`
+	Times           int    `yaml:"times"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		ListenAddress: ":8889",
+		LogLevel:      "info",
+		Cells:         []diegox.Cell{diegox.DefaultCell()},
+		DockerImages:  diegox.DefaultDockerImages(),
+	}
+}
+
+func loadConfig(path string) (*config, error) {
+	c := defaultConfig()
+	if path == "" {
+		return c, nil
+	}
+
+	bits, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = yaml.Unmarshal(bits, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *config) tlsConfig() (*tls.Config, error) {
+	if c.TLS.CertFile == "" && c.TLS.KeyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if c.TLS.CAFile != "" {
+		ca, err := ioutil.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("failed to parse TLS CA file")
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+func (c *config) faults() (map[string]diegox.Fault, error) {
+	faults := make(map[string]diegox.Fault, len(c.Faults))
+
+	for endpoint, fc := range c.Faults {
+		fault := diegox.Fault{
+			ResetConnection: fc.ResetConnection,
+			StatusCode:      fc.StatusCode,
+			Times:           fc.Times,
+		}
+
+		var err error
+		if fault.Latency, err = parseDuration(fc.Latency); err != nil {
+			return nil, fmt.Errorf("fault for %s: %s", endpoint, err)
+		}
+		if fault.LatencyJitter, err = parseDuration(fc.LatencyJitter); err != nil {
+			return nil, fmt.Errorf("fault for %s: %s", endpoint, err)
+		}
+
+		if fc.BBSError != "" {
+			errType, ok := models.Error_Type_value[fc.BBSError]
+			if !ok {
+				return nil, fmt.Errorf("fault for %s: unknown BBS error type %q", endpoint, fc.BBSError)
+			}
+			fault.BBSError = models.NewError(models.Error_Type(errType), fc.BBSError)
+		}
+
+		faults[endpoint] = fault
+	}
+
+	return faults, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -1,12 +1,187 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/mvcc/diegox"
 )
 
+var (
+	configPath    = flag.String("config", "", "path to a YAML or JSON config file")
+	listenAddress = flag.String("listenAddress", "", "address the fake BBS listens on (overrides config)")
+	adminAddress  = flag.String("adminAddress", "", "address the admin API listens on (overrides config)")
+	logLevel      = flag.String("logLevel", "", "log level: debug, info, error or fatal (overrides config)")
+	stateFile     = flag.String("stateFile", "", "path the state snapshot is loaded from on start and saved to on exit (overrides config)")
+	tlsCertFile   = flag.String("tlsCertFile", "", "path to the TLS certificate (overrides config)")
+	tlsKeyFile    = flag.String("tlsKeyFile", "", "path to the TLS private key (overrides config)")
+	tlsCAFile     = flag.String("tlsCAFile", "", "path to the CA used to verify clients (overrides config)")
+)
+
 func main() {
-	server := diegox.NewBBSServer()
-	log.Fatal(server.ListenAndServe(":8889"))
+	flag.Parse()
+
+	c, err := readConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	level, err := lager.LogLevelFromString(c.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger := lager.NewLogger("fake-bbs")
+	logger.RegisterSink(lager.NewWriterSink(os.Stdout, level))
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := diegox.NewBBSServer(
+		diegox.WithLogger(logger),
+		diegox.WithTLSConfig(tlsConfig),
+		diegox.WithCells(c.Cells...),
+		diegox.WithTaskRules(c.TaskRules...),
+		diegox.WithDockerImages(c.DockerImages...),
+	)
+
+	if err = applyFaults(server, c); err != nil {
+		log.Fatal(err)
+	}
+
+	if c.StateFile != "" {
+		if err = loadState(server, c.StateFile); err != nil {
+			log.Fatal(err)
+		}
+		go saveStateOnExit(logger, server, c.StateFile)
+	}
+
+	if c.AdminAddress != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(c.AdminAddress, adminHandler(logger, server)))
+		}()
+	}
+
+	logger.Info("listening", lager.Data{"address": c.ListenAddress, "admin-address": c.AdminAddress})
+	log.Fatal(server.ListenAndServe(c.ListenAddress))
+}
+
+func readConfig() (*config, error) {
+	c, err := loadConfig(*configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if *listenAddress != "" {
+		c.ListenAddress = *listenAddress
+	}
+	if *adminAddress != "" {
+		c.AdminAddress = *adminAddress
+	}
+	if *logLevel != "" {
+		c.LogLevel = *logLevel
+	}
+	if *stateFile != "" {
+		c.StateFile = *stateFile
+	}
+	if *tlsCertFile != "" {
+		c.TLS.CertFile = *tlsCertFile
+	}
+	if *tlsKeyFile != "" {
+		c.TLS.KeyFile = *tlsKeyFile
+	}
+	if *tlsCAFile != "" {
+		c.TLS.CAFile = *tlsCAFile
+	}
+
+	return c, nil
+}
+
+func loadState(server *diegox.BBSServer, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return server.ImportState(f)
+}
+
+func saveStateOnExit(logger lager.Logger, server *diegox.BBSServer, path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	f, err := os.Create(path)
+	if err != nil {
+		logger.Fatal("failed to create state file", err)
+	}
+
+	if err = server.ExportState(f); err != nil {
+		logger.Fatal("failed to save state", err)
+	}
+	f.Close()
+
+	logger.Info("saved state", lager.Data{"path": path})
+	os.Exit(0)
+}
+
+func applyFaults(server *diegox.BBSServer, c *config) error {
+	faults, err := c.faults()
+	if err != nil {
+		return err
+	}
+
+	server.ClearFaults()
+	for endpoint, fault := range faults {
+		server.InjectFault(endpoint, fault)
+	}
+
+	return nil
+}
+
+// adminHandler extends the server's admin API with POST /reload, which
+// re-reads the config file and applies its task rules, cells, docker images
+// and faults.
+// Listen addresses, TLS and log level only change on restart.
+func adminHandler(logger lager.Logger, server *diegox.BBSServer) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", server.AdminHandler())
+
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		c, err := readConfig()
+		if err != nil {
+			logger.Error("failed to reload config", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err = applyFaults(server, c); err != nil {
+			logger.Error("failed to reload faults", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		server.SetTaskRules(c.TaskRules...)
+		server.SetCells(c.Cells...)
+		server.SetDockerImages(c.DockerImages...)
+
+		logger.Info("reloaded config")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
 }
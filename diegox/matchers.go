@@ -0,0 +1,67 @@
+package diegox
+
+import (
+	"code.cloudfoundry.org/bbs/models"
+	"github.com/onsi/gomega"
+	"github.com/onsi/gomega/types"
+)
+
+// The matchers below operate on *models.DesireTaskRequest, e.g.
+//
+//	Expect(bbsServer.DesiredTasks()).To(ContainElement(SatisfyAll(
+//		HaveTaskGUID(task.UUID),
+//		HaveTaskMemoryMB(256),
+//	)))
+
+func HaveTaskGUID(guid string) types.GomegaMatcher {
+	return gomega.WithTransform(func(req *models.DesireTaskRequest) string {
+		return req.TaskGuid
+	}, gomega.Equal(guid))
+}
+
+func HaveTaskMemoryMB(memoryMB int) types.GomegaMatcher {
+	return gomega.WithTransform(func(req *models.DesireTaskRequest) int32 {
+		return req.GetTaskDefinition().GetMemoryMb()
+	}, gomega.BeEquivalentTo(memoryMB))
+}
+
+func HaveTaskDiskMB(diskMB int) types.GomegaMatcher {
+	return gomega.WithTransform(func(req *models.DesireTaskRequest) int32 {
+		return req.GetTaskDefinition().GetDiskMb()
+	}, gomega.BeEquivalentTo(diskMB))
+}
+
+func HaveTaskEnvironmentVariable(name, value string) types.GomegaMatcher {
+	return gomega.WithTransform(func(req *models.DesireTaskRequest) map[string]string {
+		env := make(map[string]string)
+		for _, v := range req.GetTaskDefinition().GetEnvironmentVariables() {
+			env[v.Name] = v.Value
+		}
+		return env
+	}, gomega.HaveKeyWithValue(name, value))
+}
+
+func HaveTaskLogGUID(logGUID string) types.GomegaMatcher {
+	return gomega.WithTransform(func(req *models.DesireTaskRequest) string {
+		return req.GetTaskDefinition().GetLogGuid()
+	}, gomega.Equal(logGUID))
+}
+
+func HaveTaskPlacementTags(tags ...string) types.GomegaMatcher {
+	return gomega.WithTransform(func(req *models.DesireTaskRequest) []string {
+		return req.GetTaskDefinition().GetPlacementTags()
+	}, gomega.ConsistOf(tags))
+}
+
+func HaveTaskImageCredentials(username, password string) types.GomegaMatcher {
+	return gomega.WithTransform(func(req *models.DesireTaskRequest) []string {
+		def := req.GetTaskDefinition()
+		return []string{def.GetImageUsername(), def.GetImagePassword()}
+	}, gomega.Equal([]string{username, password}))
+}
+
+func HaveTaskEgressRule(rule *models.SecurityGroupRule) types.GomegaMatcher {
+	return gomega.WithTransform(func(req *models.DesireTaskRequest) []*models.SecurityGroupRule {
+		return req.GetTaskDefinition().GetEgressRules()
+	}, gomega.ContainElement(rule))
+}
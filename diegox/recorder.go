@@ -0,0 +1,97 @@
+package diegox
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"github.com/gogo/protobuf/proto"
+)
+
+// RecordedRequest is a request decoded by the server. Message is nil for
+// endpoints that take no request body, such as /v1/ping.
+type RecordedRequest struct {
+	Endpoint   string
+	ReceivedAt time.Time
+	Message    proto.Message
+}
+
+type requestRecorder struct {
+	mu       sync.RWMutex
+	requests []RecordedRequest
+}
+
+func (r *requestRecorder) record(endpoint string, message proto.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, RecordedRequest{
+		Endpoint:   endpoint,
+		ReceivedAt: time.Now(),
+		Message:    message,
+	})
+}
+
+func (r *requestRecorder) list(endpoints ...string) []RecordedRequest {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var requests []RecordedRequest
+	for _, req := range r.requests {
+		if len(endpoints) > 0 && !containsString(endpoints, req.Endpoint) {
+			continue
+		}
+		requests = append(requests, req)
+	}
+
+	return requests
+}
+
+func (r *requestRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = nil
+}
+
+// Requests lists every request the server has decoded, in the order they
+// were received, optionally limited to the given endpoints.
+func (s *BBSServer) Requests(endpoints ...string) []RecordedRequest {
+	return s.recorder.list(endpoints...)
+}
+
+// DesiredTasks lists the DesireTaskRequests the server has received.
+func (s *BBSServer) DesiredTasks() []*models.DesireTaskRequest {
+	var reqs []*models.DesireTaskRequest
+	for _, recorded := range s.recorder.list(DesireTaskEndpoint) {
+		reqs = append(reqs, recorded.Message.(*models.DesireTaskRequest))
+	}
+
+	return reqs
+}
+
+// DesiredTask returns the most recent DesireTaskRequest for the task.
+func (s *BBSServer) DesiredTask(taskGUID string) (*models.DesireTaskRequest, bool) {
+	reqs := s.DesiredTasks()
+	for i := len(reqs) - 1; i >= 0; i-- {
+		if reqs[i].TaskGuid == taskGUID {
+			return reqs[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// ResetRequests forgets every request recorded so far.
+func (s *BBSServer) ResetRequests() {
+	s.recorder.reset()
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package diegox
+
+import (
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+// TaskRule decides the outcome reported to the completion callback of a
+// desired task. Empty match fields match every task.
+type TaskRule struct {
+	Domain         string `json:"domain" yaml:"domain"`
+	TaskGUIDPrefix string `json:"task_guid_prefix" yaml:"task_guid_prefix"`
+
+	Fail          bool   `json:"fail" yaml:"fail"`
+	FailureReason string `json:"failure_reason" yaml:"failure_reason"`
+}
+
+func (r TaskRule) matches(req *models.DesireTaskRequest) bool {
+	if r.Domain != "" && r.Domain != req.Domain {
+		return false
+	}
+	if r.TaskGUIDPrefix != "" && !strings.HasPrefix(req.TaskGuid, r.TaskGUIDPrefix) {
+		return false
+	}
+	return true
+}
+
+type taskRules struct {
+	mu    sync.RWMutex
+	rules []TaskRule
+}
+
+func (t *taskRules) set(rules []TaskRule) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rules = append([]TaskRule(nil), rules...)
+}
+
+func (t *taskRules) list() []TaskRule {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return append([]TaskRule(nil), t.rules...)
+}
+
+// match returns the first rule matching the request. Tasks that match no
+// rule succeed.
+func (t *taskRules) match(req *models.DesireTaskRequest) TaskRule {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, rule := range t.rules {
+		if rule.matches(req) {
+			return rule
+		}
+	}
+
+	return TaskRule{}
+}
+
+// SetTaskRules replaces the rules deciding task outcomes. Rules are evaluated
+// in order and the first match wins.
+func (s *BBSServer) SetTaskRules(rules ...TaskRule) {
+	s.rules.set(rules)
+}
+
+func (s *BBSServer) TaskRules() []TaskRule {
+	return s.rules.list()
+}
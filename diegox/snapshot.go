@@ -0,0 +1,253 @@
+package diegox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+// Snapshot is the durable state of a BBSServer. Recorded requests, callbacks,
+// events and faults are deliberately left out: they describe what happened
+// to a server rather than what it holds.
+type Snapshot struct {
+	Cells        []Cell                         `json:"cells"`
+	Domains      map[string]time.Time           `json:"domains"`
+	TaskRules    []TaskRule                     `json:"task_rules"`
+	DockerImages []DockerImage                  `json:"docker_images"`
+	DesiredLRPs  []*models.DesiredLRP           `json:"desired_lrps"`
+	ActualLRPs   map[string][]*models.ActualLRP `json:"actual_lrps"`
+	Tasks        []*models.Task                 `json:"tasks"`
+}
+
+func (d *domainStore) snapshot() map[string]time.Time {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	domains := make(map[string]time.Time, len(d.domains))
+	for domain, expiresAt := range d.domains {
+		domains[domain] = expiresAt
+	}
+
+	return domains
+}
+
+func (d *domainStore) restore(domains map[string]time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.domains = make(map[string]time.Time, len(domains))
+	for domain, expiresAt := range domains {
+		d.domains[domain] = expiresAt
+	}
+}
+
+func (l *lrpStore) snapshot() ([]*models.DesiredLRP, map[string][]*models.ActualLRP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	desired := make([]*models.DesiredLRP, 0, len(l.desired))
+	for _, lrp := range l.desired {
+		desired = append(desired, lrp.Copy())
+	}
+	sort.Slice(desired, func(i, j int) bool {
+		return desired[i].ProcessGuid < desired[j].ProcessGuid
+	})
+
+	actual := make(map[string][]*models.ActualLRP, len(l.actual))
+	for processGuid, actuals := range l.actual {
+		for _, a := range actuals {
+			copied := *a
+			actual[processGuid] = append(actual[processGuid], &copied)
+		}
+	}
+
+	return desired, actual
+}
+
+func (l *lrpStore) restore(desired []*models.DesiredLRP, actual map[string][]*models.ActualLRP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.desired = make(map[string]*models.DesiredLRP, len(desired))
+	for _, lrp := range desired {
+		l.desired[lrp.ProcessGuid] = lrp
+	}
+
+	l.actual = make(map[string][]*models.ActualLRP, len(actual))
+	for processGuid, actuals := range actual {
+		l.actual[processGuid] = actuals
+	}
+}
+
+// restore replaces the tasks, abandoning the current ones, and runs the
+// restored tasks that have not completed again.
+func (t *taskRunner) restore(tasks []*models.Task) {
+	t.mu.Lock()
+	for taskGuid, task := range t.tasks {
+		if task.State != models.Task_Completed {
+			close(t.cancelled[taskGuid])
+		}
+	}
+
+	t.tasks = make(map[string]*models.Task, len(tasks))
+	t.cancelled = make(map[string]chan struct{}, len(tasks))
+	executionTimeout := t.executionTimeoutLocked()
+
+	type launch struct {
+		req       *models.DesireTaskRequest
+		cancelled chan struct{}
+	}
+	var launches []launch
+	for _, task := range tasks {
+		copied := *task
+		t.tasks[task.TaskGuid] = &copied
+		t.cancelled[task.TaskGuid] = make(chan struct{})
+
+		if task.State == models.Task_Completed {
+			continue
+		}
+		launches = append(launches, launch{
+			req: &models.DesireTaskRequest{
+				TaskDefinition: task.TaskDefinition,
+				TaskGuid:       task.TaskGuid,
+				Domain:         task.Domain,
+			},
+			cancelled: t.cancelled[task.TaskGuid],
+		})
+	}
+	t.mu.Unlock()
+
+	for _, l := range launches {
+		t.launch(l.req, l.cancelled, executionTimeout)
+	}
+}
+
+// drop forgets the desired LRP and its instances without emitting events.
+func (l *lrpStore) drop(processGuid string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.desired[processGuid]
+	delete(l.desired, processGuid)
+	delete(l.actual, processGuid)
+
+	return ok
+}
+
+// inject stores the desired LRP and starts its instances without emitting
+// events.
+func (l *lrpStore) inject(lrp *models.DesiredLRP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.desired[lrp.ProcessGuid] = lrp
+
+	var actuals []*models.ActualLRP
+	for i := int32(0); i < lrp.Instances; i++ {
+		actuals = append(actuals, l.newRunningActualLRP(lrp, i))
+	}
+	l.actual[lrp.ProcessGuid] = actuals
+}
+
+func (s *BBSServer) Snapshot() Snapshot {
+	desired, actual := s.lrps.snapshot()
+
+	return Snapshot{
+		Cells:        s.Cells(),
+		Domains:      s.domains.snapshot(),
+		TaskRules:    s.TaskRules(),
+		DockerImages: s.DockerImages(),
+		DesiredLRPs:  desired,
+		ActualLRPs:   actual,
+		Tasks:        s.Tasks(),
+	}
+}
+
+// validate rejects snapshots Restore would fail part way through, e.g.
+// because an LRP is null.
+func (snapshot Snapshot) validate() error {
+	for i, lrp := range snapshot.DesiredLRPs {
+		if lrp == nil {
+			return &ErrInvalidSnapshot{Reason: fmt.Sprintf("desired_lrps[%d] is null", i)}
+		}
+		if lrp.ProcessGuid == "" {
+			return &ErrInvalidSnapshot{Reason: fmt.Sprintf("desired_lrps[%d] has no process_guid", i)}
+		}
+	}
+
+	for processGuid, actuals := range snapshot.ActualLRPs {
+		for i, actual := range actuals {
+			if actual == nil {
+				return &ErrInvalidSnapshot{Reason: fmt.Sprintf("actual_lrps[%q][%d] is null", processGuid, i)}
+			}
+		}
+	}
+
+	taskGuids := make(map[string]bool, len(snapshot.Tasks))
+	for i, task := range snapshot.Tasks {
+		switch {
+		case task == nil:
+			return &ErrInvalidSnapshot{Reason: fmt.Sprintf("tasks[%d] is null", i)}
+		case task.TaskGuid == "":
+			return &ErrInvalidSnapshot{Reason: fmt.Sprintf("tasks[%d] has no task_guid", i)}
+		case task.TaskDefinition == nil:
+			return &ErrInvalidSnapshot{Reason: fmt.Sprintf("task %s has no task definition", task.TaskGuid)}
+		case taskGuids[task.TaskGuid]:
+			return &ErrInvalidSnapshot{Reason: fmt.Sprintf("task %s is duplicated", task.TaskGuid)}
+		}
+		taskGuids[task.TaskGuid] = true
+	}
+
+	return nil
+}
+
+// Restore replaces the server's state with the snapshot. A snapshot without
+// DockerImages keeps the current image catalogue. Tasks that had not
+// completed are run again, and the server's current tasks are abandoned
+// without callbacks. Invalid snapshots are rejected before any
+// state is replaced.
+func (s *BBSServer) Restore(snapshot Snapshot) error {
+	if err := snapshot.validate(); err != nil {
+		return err
+	}
+
+	s.cells.set(snapshot.Cells)
+	s.domains.restore(snapshot.Domains)
+	s.rules.set(snapshot.TaskRules)
+	if snapshot.DockerImages != nil {
+		s.images.set(snapshot.DockerImages)
+	}
+	s.lrps.restore(snapshot.DesiredLRPs, snapshot.ActualLRPs)
+	s.tasks.restore(snapshot.Tasks)
+
+	return nil
+}
+
+func (s *BBSServer) ExportState(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s.Snapshot())
+}
+
+func (s *BBSServer) ImportState(r io.Reader) error {
+	var snapshot Snapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	return s.Restore(snapshot)
+}
+
+// DropDesiredLRP removes a desired LRP behind the cloud controller's back, as
+// if BBS had lost it, so that CC's sync has something to repair.
+func (s *BBSServer) DropDesiredLRP(processGuid string) bool {
+	return s.lrps.drop(processGuid)
+}
+
+// InjectDesiredLRP adds a desired LRP the cloud controller never asked for,
+// e.g. a stale one left over from a deleted app.
+func (s *BBSServer) InjectDesiredLRP(lrp *models.DesiredLRP) {
+	s.lrps.inject(lrp)
+}
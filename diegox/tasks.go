@@ -0,0 +1,518 @@
+package diegox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	TasksEndpoint      = "/v1/tasks/list.r2"
+	TaskByGuidEndpoint = "/v1/tasks/get_by_task_guid.r2"
+	CancelTaskEndpoint = "/v1/tasks/cancel"
+
+	// StagingDomain is the domain the cloud controller desires staging tasks
+	// in. Staging tasks complete as soon as they are desired.
+	StagingDomain = "cf-app-staging"
+
+	DefaultTaskExecutionTimeout = time.Minute
+
+	// taskPath is the PATH locally executed tasks get, rather than the
+	// test's.
+	taskPath = "/usr/local/bin:/usr/bin:/bin"
+
+	taskCancelledReason = "task was cancelled"
+)
+
+// taskRunner takes desired tasks through PENDING, RUNNING and COMPLETED and
+// reports the outcome to their completion callbacks, the way Diego's
+// auctioneer, rep and executor do between them.
+type taskRunner struct {
+	logger    lager.Logger
+	cells     *cellRegistry
+	rules     *taskRules
+	images    *imageCatalogue
+	callbacks *callbackQueue
+
+	pendingDuration time.Duration
+	runDuration     time.Duration
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+
+	mu        sync.Mutex
+	tasks     map[string]*models.Task
+	cancelled map[string]chan struct{}
+
+	// executionTimeout is how long tasks are executed locally for, if
+	// execute is set. Tasks keep the setting they were desired with.
+	execute          bool
+	executionTimeout time.Duration
+}
+
+func newTaskRunner(logger lager.Logger, cells *cellRegistry, rules *taskRules, images *imageCatalogue, callbacks *callbackQueue, o *bbsServerOptions) *taskRunner {
+	return &taskRunner{
+		logger:           logger.Session("tasks"),
+		cells:            cells,
+		rules:            rules,
+		images:           images,
+		callbacks:        callbacks,
+		pendingDuration:  o.taskPendingDuration,
+		runDuration:      o.taskRunDuration,
+		execute:          o.taskExecution,
+		executionTimeout: o.taskExecutionTimeout,
+		done:             make(chan struct{}),
+		tasks:            make(map[string]*models.Task),
+		cancelled:        make(map[string]chan struct{}),
+	}
+}
+
+func (t *taskRunner) desire(req *models.DesireTaskRequest) error {
+	now := time.Now().UnixNano()
+	task := &models.Task{
+		TaskDefinition: req.TaskDefinition,
+		TaskGuid:       req.TaskGuid,
+		Domain:         req.Domain,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		State:          models.Task_Pending,
+	}
+
+	t.mu.Lock()
+	if _, ok := t.tasks[req.TaskGuid]; ok {
+		t.mu.Unlock()
+		return models.ErrResourceExists
+	}
+	t.tasks[req.TaskGuid] = task
+	cancelled := make(chan struct{})
+	t.cancelled[req.TaskGuid] = cancelled
+	executionTimeout := t.executionTimeoutLocked()
+	t.mu.Unlock()
+
+	t.launch(req, cancelled, executionTimeout)
+
+	return nil
+}
+
+// launch stages the task or runs it in the background.
+func (t *taskRunner) launch(req *models.DesireTaskRequest, cancelled chan struct{}, executionTimeout time.Duration) {
+	if req.Domain == StagingDomain {
+		t.stage(req, cancelled)
+		return
+	}
+
+	t.wg.Add(1)
+	go t.run(req, cancelled, executionTimeout)
+}
+
+// executionTimeoutLocked returns zero if tasks are not executed locally. It
+// must be called with the lock held.
+func (t *taskRunner) executionTimeoutLocked() time.Duration {
+	if !t.execute {
+		return 0
+	}
+	return t.executionTimeout
+}
+
+func (t *taskRunner) setExecution(execute bool, timeout time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.execute = execute
+	t.executionTimeout = timeout
+}
+
+// stage completes a staging task straight away, deriving docker staging
+// results from the image catalogue.
+func (t *taskRunner) stage(req *models.DesireTaskRequest, cancelled chan struct{}) {
+	body := &taskCallbackRequest{}
+	body.TaskGUID = req.TaskGuid
+	body.Result.TaskGUID = req.TaskGuid
+
+	if args, ok := findDockerStagingArgs(req.TaskDefinition.Action); ok {
+		if err := stageDockerImage(body, t.images, args); err != nil {
+			t.logger.Info("docker staging failed", lager.Data{"task-guid": req.TaskGuid, "error": err.Error()})
+			body.Failed = true
+			body.FailureReason = err.Error()
+		}
+	}
+
+	if rule := t.rules.match(req); rule.Fail {
+		body.Failed = true
+		body.FailureReason = rule.FailureReason
+	}
+
+	t.start(req.TaskGuid, cancelled)
+	if t.complete(req.TaskGuid, cancelled, body.Failed, body.FailureReason) {
+		t.notify(req, body)
+	}
+}
+
+// run executes the task locally for up to executionTimeout, unless it is
+// zero.
+func (t *taskRunner) run(req *models.DesireTaskRequest, cancelled chan struct{}, executionTimeout time.Duration) {
+	defer t.wg.Done()
+	logger := t.logger.Session("run", lager.Data{"task-guid": req.TaskGuid})
+
+	if !t.wait(t.pendingDuration, cancelled) {
+		return
+	}
+	t.start(req.TaskGuid, cancelled)
+
+	var failed bool
+	var failureReason string
+	if executionTimeout > 0 {
+		failed, failureReason = t.executeLocally(logger, req, cancelled, executionTimeout)
+	}
+	if !t.wait(t.runDuration, cancelled) {
+		return
+	}
+
+	if rule := t.rules.match(req); rule.Fail {
+		failed = true
+		failureReason = rule.FailureReason
+	}
+
+	body := &taskCallbackRequest{}
+	body.TaskGUID = req.TaskGuid
+	body.Result.TaskGUID = req.TaskGuid
+	body.Failed = failed
+	body.FailureReason = failureReason
+
+	if t.complete(req.TaskGuid, cancelled, failed, failureReason) {
+		t.notify(req, body)
+	}
+}
+
+// wait returns false if the task is cancelled or the runner stopped first.
+func (t *taskRunner) wait(d time.Duration, cancelled chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-cancelled:
+		return false
+	case <-t.done:
+		return false
+	}
+}
+
+// executeLocally runs the task's command with /bin/sh in a scratch
+// directory, with only the task's environment, a minimal PATH and a timeout.
+// It is not sandboxed: the command runs as the current user and can reach
+// the whole filesystem.
+func (t *taskRunner) executeLocally(logger lager.Logger, req *models.DesireTaskRequest, cancelled chan struct{}, timeout time.Duration) (bool, string) {
+	run := findRunAction(req.TaskDefinition.Action, func(*models.RunAction) bool { return true })
+	if run == nil {
+		return true, "task has no run action"
+	}
+
+	dir, err := ioutil.TempDir("", "diegox-task-")
+	if err != nil {
+		logger.Error("failed to create task directory", err)
+		return true, err.Error()
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", taskCommand(run))
+	cmd.Dir = dir
+	// The shell leads its own process group, so that its children can be
+	// killed with it; they would otherwise keep the output pipe open.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Env = []string{
+		"PATH=" + taskPath,
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+	}
+	for _, env := range req.TaskDefinition.EnvironmentVariables {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	for _, env := range run.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err = cmd.Start(); err != nil {
+		return true, err.Error()
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-cancelled:
+			cancel()
+		case <-t.done:
+			cancel()
+		case <-ctx.Done():
+		case <-exited:
+			return
+		}
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}()
+
+	err = cmd.Wait()
+	close(exited)
+	logger.Debug("executed", lager.Data{"output": output.String()})
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return true, "Exceeded timeout"
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return true, fmt.Sprintf("Exited with status %d", status.ExitStatus())
+		}
+		return true, exitErr.Error()
+	}
+	if err != nil {
+		return true, err.Error()
+	}
+
+	return false, ""
+}
+
+// taskCommand unwraps the command CC passes to the app lifecycle launcher.
+// Other run actions are executed as they are.
+func taskCommand(run *models.RunAction) string {
+	if strings.HasSuffix(run.Path, "/launcher") && len(run.Args) >= 2 {
+		return run.Args[1]
+	}
+
+	return strings.Join(append([]string{run.Path}, run.Args...), " ")
+}
+
+// start and complete ignore tasks that have been replaced by Restore since
+// cancelled was handed out.
+func (t *taskRunner) start(taskGuid string, cancelled chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	task := t.tasks[taskGuid]
+	if task == nil || task.State != models.Task_Pending || t.cancelled[taskGuid] != cancelled {
+		return
+	}
+
+	cellID := DefaultCell().ID
+	if cells := t.cells.list(); len(cells) > 0 {
+		cellID = cells[len(t.tasks)%len(cells)].ID
+	}
+
+	task.State = models.Task_Running
+	task.CellId = cellID
+	task.UpdatedAt = time.Now().UnixNano()
+}
+
+// complete returns false if the task had already completed, e.g. because it
+// was cancelled.
+func (t *taskRunner) complete(taskGuid string, cancelled chan struct{}, failed bool, failureReason string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	task := t.tasks[taskGuid]
+	if task == nil || task.State == models.Task_Completed || t.cancelled[taskGuid] != cancelled {
+		return false
+	}
+
+	t.markCompleted(task, failed, failureReason)
+	return true
+}
+
+// markCompleted must be called with the lock held.
+func (t *taskRunner) markCompleted(task *models.Task, failed bool, failureReason string) {
+	now := time.Now().UnixNano()
+	task.State = models.Task_Completed
+	task.CellId = ""
+	task.Failed = failed
+	task.FailureReason = failureReason
+	task.UpdatedAt = now
+	task.FirstCompletedAt = now
+}
+
+func (t *taskRunner) cancel(taskGuid string) error {
+	t.mu.Lock()
+	task := t.tasks[taskGuid]
+	if task == nil {
+		t.mu.Unlock()
+		return models.ErrResourceNotFound
+	}
+	if task.State == models.Task_Completed {
+		t.mu.Unlock()
+		return models.NewTaskTransitionError(task.State, models.Task_Completed)
+	}
+	close(t.cancelled[taskGuid])
+	t.markCompleted(task, true, taskCancelledReason)
+	t.mu.Unlock()
+
+	body := &taskCallbackRequest{}
+	body.TaskGUID = taskGuid
+	body.Result.TaskGUID = taskGuid
+	body.Failed = true
+	body.FailureReason = taskCancelledReason
+
+	t.notify(&models.DesireTaskRequest{TaskGuid: taskGuid, TaskDefinition: task.TaskDefinition}, body)
+
+	return nil
+}
+
+func (t *taskRunner) notify(req *models.DesireTaskRequest, body *taskCallbackRequest) {
+	if req.TaskDefinition.CompletionCallbackUrl == "" {
+		return
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.logger.Error("failed to marshal taskCallbackRequest", err)
+		return
+	}
+
+	t.callbacks.Enqueue(req.TaskGuid, req.TaskDefinition.CompletionCallbackUrl, b)
+}
+
+func (t *taskRunner) task(taskGuid string) (*models.Task, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	task, ok := t.tasks[taskGuid]
+	if !ok {
+		return nil, false
+	}
+
+	copied := *task
+	return &copied, true
+}
+
+func (t *taskRunner) list(domain, cellID string) []*models.Task {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var tasks []*models.Task
+	for _, task := range t.tasks {
+		if domain != "" && task.Domain != domain {
+			continue
+		}
+		if cellID != "" && task.CellId != cellID {
+			continue
+		}
+		copied := *task
+		tasks = append(tasks, &copied)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt < tasks[j].CreatedAt
+	})
+
+	return tasks
+}
+
+// stop abandons running tasks, leaving them in their current state.
+func (t *taskRunner) stop() {
+	t.once.Do(func() {
+		close(t.done)
+	})
+	t.wg.Wait()
+}
+
+// SetLocalTaskExecution turns WithLocalTaskExecution on for tasks desired
+// from now on, with the timeout. A non-positive timeout turns it off.
+func (s *BBSServer) SetLocalTaskExecution(timeout time.Duration) {
+	s.tasks.setExecution(timeout > 0, timeout)
+}
+
+// Tasks lists the tasks the server knows about, oldest first.
+func (s *BBSServer) Tasks() []*models.Task {
+	return s.tasks.list("", "")
+}
+
+// Task returns a copy of the task's current state.
+func (s *BBSServer) Task(taskGuid string) (*models.Task, bool) {
+	return s.tasks.task(taskGuid)
+}
+
+func desireTaskHandler(logger lager.Logger, recorder *requestRecorder, tasks *taskRunner) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.DesireTaskRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(DesireTaskEndpoint, req)
+
+		res := &models.TaskLifecycleResponse{}
+		if err := req.Validate(); err != nil {
+			res.Error = models.ConvertError(err)
+		} else if err = tasks.desire(req); err != nil {
+			res.Error = models.ConvertError(err)
+		}
+
+		writeResponse(logger, w, res)
+	}
+}
+
+func tasksHandler(logger lager.Logger, recorder *requestRecorder, tasks *taskRunner) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.TasksRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(TasksEndpoint, req)
+
+		writeResponse(logger, w, &models.TasksResponse{
+			Tasks: tasks.list(req.Domain, req.CellId),
+		})
+	}
+}
+
+func taskByGuidHandler(logger lager.Logger, recorder *requestRecorder, tasks *taskRunner) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.TaskByGuidRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(TaskByGuidEndpoint, req)
+
+		res := &models.TaskResponse{}
+		if task, ok := tasks.task(req.TaskGuid); ok {
+			res.Task = task
+		} else {
+			res.Error = models.ErrResourceNotFound
+		}
+
+		writeResponse(logger, w, res)
+	}
+}
+
+func cancelTaskHandler(logger lager.Logger, recorder *requestRecorder, tasks *taskRunner) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &models.TaskGuidRequest{}
+		if !decodeRequest(logger, w, r, req) {
+			return
+		}
+		recorder.record(CancelTaskEndpoint, req)
+
+		res := &models.TaskLifecycleResponse{}
+		if err := tasks.cancel(req.TaskGuid); err != nil {
+			res.Error = models.ConvertError(err)
+		}
+
+		writeResponse(logger, w, res)
+	}
+}
@@ -3,6 +3,8 @@ package mvcc
 import (
 	"errors"
 	"fmt"
+
+	"code.cloudfoundry.org/mvcc/internal/database"
 )
 
 var (
@@ -20,6 +22,8 @@ var (
 
 	ErrInternalServer = errors.New("internal server error")
 	ErrBadGateway     = errors.New("bad gateway")
+
+	ErrUnsupportedDatabaseAdapter = database.ErrUnsupportedAdapter
 )
 
 type ErrUnexpectedStatusCode struct {
@@ -29,3 +33,11 @@ type ErrUnexpectedStatusCode struct {
 func (e *ErrUnexpectedStatusCode) Error() string {
 	return fmt.Sprintf("unexpected error code (%d)", e.StatusCode)
 }
+
+type ErrInvalidDatabaseOptions struct {
+	Reason string
+}
+
+func (e *ErrInvalidDatabaseOptions) Error() string {
+	return fmt.Sprintf("invalid database options: %s", e.Reason)
+}
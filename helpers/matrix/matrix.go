@@ -0,0 +1,247 @@
+package matrix
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/mvcc"
+	"code.cloudfoundry.org/mvcc/patterns"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type Outcome int
+
+const (
+	// Succeeds means the call returns the resource, or lists it.
+	Succeeds Outcome = iota + 1
+	NotFound
+	Forbidden
+	// Filtered means a list call succeeds without listing the resource.
+	Filtered
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Succeeds:
+		return "succeeds"
+	case NotFound:
+		return "fails with 404"
+	case Forbidden:
+		return "fails with 403"
+	case Filtered:
+		return "filters it out"
+	}
+	return fmt.Sprintf("outcome %d", int(o))
+}
+
+// Scope is the level of the resource pattern an operation's action is
+// checked against.
+type Scope string
+
+const (
+	ScopeOrg   Scope = "org"
+	ScopeSpace Scope = "space"
+	ScopeApp   Scope = "app"
+)
+
+// Target is where the resource under test lives. App is only needed for
+// operations with ScopeApp.
+type Target struct {
+	Org   string
+	Space string
+	App   string
+}
+
+// Operation is a cloud controller endpoint guarded by a perm action.
+type Operation struct {
+	// Name describes the endpoint, e.g. "GET /v3/tasks/:guid".
+	Name   string
+	Action string
+
+	// Scope is the pattern the action is granted on, and so which scenarios
+	// are generated. It defaults to ScopeSpace, which is what the cloud
+	// controller checks for most actions.
+	Scope Scope
+
+	// List operations are filtered rather than failing when the subject
+	// lacks the action.
+	List bool
+
+	// Denied is the outcome without the action. It defaults to Filtered for
+	// lists and NotFound otherwise.
+	Denied Outcome
+
+	// Suspended is the outcome with the action granted at the space of a
+	// suspended org. It defaults to Succeeds.
+	Suspended Outcome
+
+	// Call calls the endpoint as the subject and reports whether it saw the
+	// resource under test.
+	Call func() (visible bool, err error)
+}
+
+func (op Operation) denied() Outcome {
+	switch {
+	case op.Denied != 0:
+		return op.Denied
+	case op.List:
+		return Filtered
+	default:
+		return NotFound
+	}
+}
+
+func (op Operation) scope() Scope {
+	if op.Scope != "" {
+		return op.Scope
+	}
+	return ScopeSpace
+}
+
+func (op Operation) suspended() Outcome {
+	if op.Suspended != 0 {
+		return op.Suspended
+	}
+	return Succeeds
+}
+
+// Env is how the specs reach the suite. Target is called in each spec, after
+// the suite's BeforeEach blocks have created the resource.
+type Env struct {
+	Target func() Target
+
+	// Grant gives the subject the action on the resource pattern until the
+	// spec ends.
+	Grant func(resource, action string)
+
+	// Suspend suspends the org.
+	Suspend func(org string)
+}
+
+type scenario struct {
+	name     string
+	resource func(Target) patterns.Pattern
+	suspend  bool
+	outcome  func(Operation) Outcome
+}
+
+// level is a scope's pattern for the target, and for a sibling of it.
+type level struct {
+	scope   Scope
+	pattern func(Target) patterns.Pattern
+	other   func(Target) patterns.Pattern
+}
+
+// levels run from the broadest scope to the narrowest.
+var levels = []level{
+	{
+		scope:   ScopeOrg,
+		pattern: func(t Target) patterns.Pattern { return patterns.Org(t.Org) },
+		other:   func(t Target) patterns.Pattern { return patterns.Org(mvcc.RandomUUID("other-org")) },
+	},
+	{
+		scope:   ScopeSpace,
+		pattern: func(t Target) patterns.Pattern { return patterns.Space(t.Org, t.Space) },
+		other:   func(t Target) patterns.Pattern { return patterns.Space(t.Org, mvcc.RandomUUID("other-space")) },
+	},
+	{
+		scope:   ScopeApp,
+		pattern: func(t Target) patterns.Pattern { return patterns.App(t.Org, t.Space, t.App) },
+		other:   func(t Target) patterns.Pattern { return patterns.App(t.Org, t.Space, mvcc.RandomUUID("other-app")) },
+	},
+}
+
+// scenariosFor grants the action at the operation's scope and at every broader
+// one, at a sibling of each, not at all, and at the scope of a suspended
+// org.
+func scenariosFor(scope Scope) ([]scenario, error) {
+	var covering []level
+	for _, l := range levels {
+		covering = append(covering, l)
+		if l.scope == scope {
+			break
+		}
+	}
+	if covering[len(covering)-1].scope != scope {
+		return nil, fmt.Errorf("unknown scope %q", scope)
+	}
+
+	var granted, others []scenario
+	for i := len(covering) - 1; i >= 0; i-- {
+		l := covering[i]
+		granted = append(granted, scenario{
+			name:     fmt.Sprintf("granted at the %s", l.scope),
+			resource: l.pattern,
+			outcome:  func(Operation) Outcome { return Succeeds },
+		})
+		others = append(others, scenario{
+			name:     fmt.Sprintf("granted at another %s", l.scope),
+			resource: l.other,
+			outcome:  Operation.denied,
+		})
+	}
+
+	all := append(granted, others...)
+	all = append(all,
+		scenario{
+			name:    "not granted",
+			outcome: Operation.denied,
+		},
+		scenario{
+			name:     fmt.Sprintf("granted at the %s of a suspended org", scope),
+			resource: covering[len(covering)-1].pattern,
+			suspend:  true,
+			outcome:  Operation.suspended,
+		},
+	)
+
+	return all, nil
+}
+
+// Describe generates a spec per scenario: the action granted at the
+// operation's scope and at each broader scope, at another resource at each
+// of those scopes, not at all, and at the scope of a suspended org.
+func Describe(op Operation, env Env) bool {
+	scenarios, err := scenariosFor(op.scope())
+	if err != nil {
+		panic(fmt.Sprintf("matrix: %s: %s", op.Name, err))
+	}
+
+	return ginkgo.Describe(op.Name, func() {
+		for _, s := range scenarios {
+			s := s
+			outcome := s.outcome(op)
+
+			ginkgo.It(fmt.Sprintf("%s when `%s` is %s", outcome, op.Action, s.name), func() {
+				target := env.Target()
+
+				if s.resource != nil {
+					env.Grant(s.resource(target).String(), op.Action)
+				}
+				if s.suspend {
+					env.Suspend(target.Org)
+				}
+
+				visible, err := op.Call()
+				expectOutcome(outcome, visible, err)
+			})
+		}
+	})
+}
+
+func expectOutcome(outcome Outcome, visible bool, err error) {
+	switch outcome {
+	case Succeeds:
+		Expect(err).NotTo(HaveOccurred())
+		Expect(visible).To(BeTrue(), "expected the resource to be visible")
+	case NotFound:
+		Expect(err).To(MatchError(mvcc.ErrNotFound))
+	case Forbidden:
+		Expect(err).To(MatchError(mvcc.ErrForbidden))
+	case Filtered:
+		Expect(err).NotTo(HaveOccurred())
+		Expect(visible).To(BeFalse(), "expected the resource to be filtered out")
+	default:
+		ginkgo.Fail(fmt.Sprintf("unknown outcome %d", int(outcome)))
+	}
+}
@@ -0,0 +1,348 @@
+package parity
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/mvcc"
+	"code.cloudfoundry.org/perm/pkg/perm"
+)
+
+// Via is how a CCDB role is assigned.
+type Via int
+
+const (
+	ViaV2Associations Via = iota
+	ViaV3Roles
+)
+
+// CCDBRole is a role the cloud controller keeps in its own database.
+type CCDBRole struct {
+	Name string
+
+	// Space roles are assigned in the target's space, org roles in its org.
+	Space bool
+
+	// Association is the role's v2 association, e.g. "developers", and
+	// Type its v3 role type, e.g. "space_developer".
+	Association string
+	Type        string
+}
+
+var (
+	OrgUser           = CCDBRole{Name: "OrgUser", Association: "users", Type: "organization_user"}
+	OrgAuditor        = CCDBRole{Name: "OrgAuditor", Association: "auditors", Type: "organization_auditor"}
+	OrgBillingManager = CCDBRole{Name: "OrgBillingManager", Association: "billing_managers", Type: "organization_billing_manager"}
+	OrgManager        = CCDBRole{Name: "OrgManager", Association: "managers", Type: "organization_manager"}
+
+	SpaceAuditor   = CCDBRole{Name: "SpaceAuditor", Space: true, Association: "auditors", Type: "space_auditor"}
+	SpaceDeveloper = CCDBRole{Name: "SpaceDeveloper", Space: true, Association: "developers", Type: "space_developer"}
+	SpaceManager   = CCDBRole{Name: "SpaceManager", Space: true, Association: "managers", Type: "space_manager"}
+)
+
+// Target is the org and space the roles are assigned in.
+type Target struct {
+	Org   string
+	Space string
+}
+
+// Role pairs a CCDB role with the perm permissions that should authorize
+// the same operations.
+type Role struct {
+	CCDB        CCDBRole
+	Permissions func(Target) []perm.Permission
+}
+
+// Operation is a request to the cloud controller. Before, if set, runs
+// before each of the two requests, e.g. to recreate a resource the first
+// request deleted.
+type Operation struct {
+	Name   string
+	Method string
+	Path   string
+	Body   interface{}
+	Before func()
+}
+
+// Subject is a user the checker can authorize both ways.
+type Subject struct {
+	UUID        string
+	AccessToken string
+	Actor       perm.Actor
+}
+
+// Env is how the checker reaches the suite.
+type Env struct {
+	CC         *mvcc.MVCC
+	AdminToken string
+
+	// NewSubject returns a user no role has been assigned to yet.
+	NewSubject func() Subject
+
+	// Grant gives the actor the permissions until the spec ends.
+	Grant func(actor perm.Actor, permissions ...perm.Permission) error
+
+	Via Via
+}
+
+// Response is what the checker compares: the status code, the GUID of the
+// resource in the body, and the GUIDs of the resources in a list.
+type Response struct {
+	StatusCode int      `json:"status_code"`
+	GUID       string   `json:"guid,omitempty"`
+	Listed     []string `json:"listed,omitempty"`
+}
+
+type Difference struct {
+	Field string `json:"field"`
+	CCDB  string `json:"ccdb"`
+	Perm  string `json:"perm"`
+}
+
+type Result struct {
+	Operation   string       `json:"operation"`
+	Role        string       `json:"role"`
+	CCDB        Response     `json:"ccdb"`
+	Perm        Response     `json:"perm"`
+	Differences []Difference `json:"differences,omitempty"`
+}
+
+// Report holds a result per operation.
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Differences returns the results whose responses differ.
+func (r Report) Differences() []Result {
+	var differ []Result
+	for _, result := range r.Results {
+		if len(result.Differences) > 0 {
+			differ = append(differ, result)
+		}
+	}
+
+	return differ
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	for _, result := range r.Results {
+		status := "ok"
+		if len(result.Differences) > 0 {
+			status = "DIFFERS"
+		}
+		fmt.Fprintf(&b, "%s as %s: %s\n", result.Operation, result.Role, status)
+		for _, d := range result.Differences {
+			fmt.Fprintf(&b, "  %s: ccdb %s, perm %s\n", d.Field, d.CCDB, d.Perm)
+		}
+	}
+
+	return b.String()
+}
+
+// Check gives one new subject the CCDB role and another the equivalent perm
+// permissions in the target, runs each operation as both and reports how
+// their responses differ.
+func Check(env Env, role Role, target Target, ops ...Operation) (Report, error) {
+	ccdbSubject := env.NewSubject()
+	if err := assignCCDBRole(env, role.CCDB, target, ccdbSubject.UUID); err != nil {
+		return Report{}, fmt.Errorf("assigning %s: %s", role.CCDB.Name, err)
+	}
+
+	permSubject := env.NewSubject()
+	if err := env.Grant(permSubject.Actor, role.Permissions(target)...); err != nil {
+		return Report{}, fmt.Errorf("granting the perm equivalent of %s: %s", role.CCDB.Name, err)
+	}
+
+	var report Report
+	for _, op := range ops {
+		ccdbRes, err := do(env.CC, op, ccdbSubject.AccessToken)
+		if err != nil {
+			return report, err
+		}
+
+		permRes, err := do(env.CC, op, permSubject.AccessToken)
+		if err != nil {
+			return report, err
+		}
+
+		report.Results = append(report.Results, Result{
+			Operation:   op.Name,
+			Role:        role.CCDB.Name,
+			CCDB:        ccdbRes,
+			Perm:        permRes,
+			Differences: compare(ccdbRes, permRes),
+		})
+	}
+
+	return report, nil
+}
+
+func assignCCDBRole(env Env, role CCDBRole, target Target, userUUID string) error {
+	cc := env.CC
+	if err := cc.V2CreateUser(env.AdminToken, userUUID); err != nil {
+		return err
+	}
+
+	if env.Via == ViaV3Roles {
+		if role.Space {
+			if err := cc.V3CreateOrganizationRole(env.AdminToken, OrgUser.Type, target.Org, userUUID); err != nil {
+				return err
+			}
+			return cc.V3CreateSpaceRole(env.AdminToken, role.Type, target.Space, userUUID)
+		}
+		return cc.V3CreateOrganizationRole(env.AdminToken, role.Type, target.Org, userUUID)
+	}
+
+	if role.Space {
+		if err := cc.V2AssociateOrganizationRole(env.AdminToken, target.Org, OrgUser.Association, userUUID); err != nil {
+			return err
+		}
+		return cc.V2AssociateSpaceRole(env.AdminToken, target.Space, role.Association, userUUID)
+	}
+	return cc.V2AssociateOrganizationRole(env.AdminToken, target.Org, role.Association, userUUID)
+}
+
+// do sends the request itself rather than through MVCC.Do, which fails on
+// the empty bodies of 204s.
+func do(cc *mvcc.MVCC, op Operation, authToken string) (Response, error) {
+	if op.Before != nil {
+		op.Before()
+	}
+
+	var reqBody io.Reader
+	if op.Body != nil {
+		bits, err := json.Marshal(op.Body)
+		if err != nil {
+			return Response{}, err
+		}
+		reqBody = bytes.NewReader(bits)
+	}
+
+	req, err := http.NewRequest(op.Method, cc.URL()+op.Path, reqBody)
+	if err != nil {
+		return Response{}, err
+	}
+	if op.Body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", authToken)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Response{}, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return Response{}, err
+	}
+
+	response := Response{StatusCode: res.StatusCode}
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return response, nil
+	}
+
+	var resource struct {
+		v3or2Resource
+		Resources []v3or2Resource `json:"resources"`
+	}
+	if err = json.Unmarshal(body, &resource); err != nil {
+		// Not a resource, so there is nothing to compare but the status.
+		return response, nil
+	}
+
+	response.GUID = resource.guid()
+	for _, r := range resource.Resources {
+		response.Listed = append(response.Listed, r.guid())
+	}
+	sort.Strings(response.Listed)
+
+	return response, nil
+}
+
+// v3or2Resource finds the GUID of v3 resources and of v2 ones, which keep
+// it in their metadata.
+type v3or2Resource struct {
+	GUID     string `json:"guid"`
+	Metadata struct {
+		GUID string `json:"guid"`
+	} `json:"metadata"`
+}
+
+func (r v3or2Resource) guid() string {
+	if r.GUID != "" {
+		return r.GUID
+	}
+	return r.Metadata.GUID
+}
+
+func compare(ccdb, perm Response) []Difference {
+	var differences []Difference
+
+	if ccdb.StatusCode != perm.StatusCode {
+		differences = append(differences, Difference{
+			Field: "status code",
+			CCDB:  fmt.Sprint(ccdb.StatusCode),
+			Perm:  fmt.Sprint(perm.StatusCode),
+		})
+	}
+
+	if ccdb.GUID != perm.GUID {
+		differences = append(differences, Difference{
+			Field: "resource",
+			CCDB:  describeGUID(ccdb.GUID),
+			Perm:  describeGUID(perm.GUID),
+		})
+	}
+
+	if onlyCCDB, onlyPerm := diff(ccdb.Listed, perm.Listed); len(onlyCCDB) > 0 || len(onlyPerm) > 0 {
+		differences = append(differences, Difference{
+			Field: "list",
+			CCDB:  fmt.Sprintf("also lists %v", onlyCCDB),
+			Perm:  fmt.Sprintf("also lists %v", onlyPerm),
+		})
+	}
+
+	return differences
+}
+
+func describeGUID(guid string) string {
+	if guid == "" {
+		return "no resource"
+	}
+	return guid
+}
+
+// diff returns the GUIDs only a lists and the GUIDs only b lists.
+func diff(a, b []string) ([]string, []string) {
+	in := func(list []string, s string) bool {
+		for _, l := range list {
+			if l == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	var onlyA, onlyB []string
+	for _, s := range a {
+		if !in(b, s) {
+			onlyA = append(onlyA, s)
+		}
+	}
+	for _, s := range b {
+		if !in(a, s) {
+			onlyB = append(onlyB, s)
+		}
+	}
+
+	return onlyA, onlyB
+}
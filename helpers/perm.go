@@ -0,0 +1,235 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"code.cloudfoundry.org/perm/pkg/api/protos"
+	"code.cloudfoundry.org/perm/pkg/perm"
+	uuid "github.com/satori/go.uuid"
+	"google.golang.org/grpc"
+)
+
+// RoleClient is the part of *perm.Client that grants use.
+type RoleClient interface {
+	CreateRole(ctx context.Context, name string, permissions ...perm.Permission) (perm.Role, error)
+	DeleteRole(ctx context.Context, name string) error
+	AssignRole(ctx context.Context, roleName string, actor perm.Actor) error
+	UnassignRole(ctx context.Context, roleName string, actor perm.Actor) error
+}
+
+// GroupRoleClient assigns roles to groups, which *perm.Client cannot do.
+type GroupRoleClient interface {
+	AssignRoleToGroup(ctx context.Context, roleName string, group perm.Group) error
+	UnassignRoleFromGroup(ctx context.Context, roleName string, group perm.Group) error
+}
+
+type groupRoleClient struct {
+	client protos.RoleServiceClient
+}
+
+// NewGroupRoleClient talks to perm's role service over the connection.
+func NewGroupRoleClient(conn *grpc.ClientConn) GroupRoleClient {
+	return &groupRoleClient{client: protos.NewRoleServiceClient(conn)}
+}
+
+func (c *groupRoleClient) AssignRoleToGroup(ctx context.Context, roleName string, group perm.Group) error {
+	_, err := c.client.AssignRoleToGroup(ctx, &protos.AssignRoleToGroupRequest{
+		RoleName: roleName,
+		Group:    &protos.Group{ID: group.ID},
+	})
+	return err
+}
+
+func (c *groupRoleClient) UnassignRoleFromGroup(ctx context.Context, roleName string, group perm.Group) error {
+	_, err := c.client.UnassignRoleFromGroup(ctx, &protos.UnassignRoleFromGroupRequest{
+		RoleName: roleName,
+		Group:    &protos.Group{ID: group.ID},
+	})
+	return err
+}
+
+// Cleanups collects functions to run after a spec, last registered first,
+// like Ginkgo 2's DeferCleanup. The suite runs them in an AfterEach.
+type Cleanups struct {
+	mu  sync.Mutex
+	fns []func() error
+}
+
+func (c *Cleanups) Defer(fn func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fns = append(c.fns, fn)
+}
+
+// Run runs and forgets every registered function, returning the first error.
+func (c *Cleanups) Run() error {
+	c.mu.Lock()
+	fns := c.fns
+	c.fns = nil
+	c.mu.Unlock()
+
+	var firstErr error
+	for i := len(fns) - 1; i >= 0; i-- {
+		if err := fns[i](); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// On is the permissions for the actions on the resource, e.g.
+//
+//	On(patterns.Space(org.UUID, space.UUID).String(), "task.read", "task.write")
+func On(resource string, actions ...string) []perm.Permission {
+	permissions := make([]perm.Permission, 0, len(actions))
+	for _, action := range actions {
+		permissions = append(permissions, perm.Permission{
+			Action:          action,
+			ResourcePattern: resource,
+		})
+	}
+
+	return permissions
+}
+
+type granterOptions struct {
+	groups   GroupRoleClient
+	cleanups *Cleanups
+}
+
+type GranterOption func(*granterOptions)
+
+func defaultGranterOptions() *granterOptions {
+	return &granterOptions{}
+}
+
+// WithGroupRoleClient lets the granter grant to groups.
+func WithGroupRoleClient(client GroupRoleClient) GranterOption {
+	return func(o *granterOptions) {
+		o.groups = client
+	}
+}
+
+// WithCleanups revokes every grant when the cleanups run.
+func WithCleanups(cleanups *Cleanups) GranterOption {
+	return func(o *granterOptions) {
+		o.cleanups = cleanups
+	}
+}
+
+// Granter grants permissions by creating a role holding them and assigning
+// it, so a spec can declare what its subject may do in one call.
+type Granter struct {
+	roles    RoleClient
+	groups   GroupRoleClient
+	cleanups *Cleanups
+}
+
+func NewGranter(roles RoleClient, opts ...GranterOption) *Granter {
+	o := defaultGranterOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return &Granter{
+		roles:    roles,
+		groups:   o.groups,
+		cleanups: o.cleanups,
+	}
+}
+
+// Grant is a role created for one grant. Revoke unassigns and deletes it.
+type Grant struct {
+	RoleName    string
+	Permissions []perm.Permission
+
+	unassign func(ctx context.Context) error
+	roles    RoleClient
+	once     sync.Once
+	err      error
+}
+
+// GrantActor gives the actor the permissions.
+func (g *Granter) GrantActor(actor perm.Actor, permissions ...perm.Permission) (*Grant, error) {
+	return g.grant(permissions,
+		func(ctx context.Context, roleName string) error {
+			return g.roles.AssignRole(ctx, roleName, actor)
+		},
+		func(ctx context.Context, roleName string) error {
+			return g.roles.UnassignRole(ctx, roleName, actor)
+		},
+	)
+}
+
+// GrantGroup gives the group's members the permissions. The granter needs a
+// GroupRoleClient.
+func (g *Granter) GrantGroup(group perm.Group, permissions ...perm.Permission) (*Grant, error) {
+	if g.groups == nil {
+		return nil, fmt.Errorf("cannot grant to group %q without a group role client", group.ID)
+	}
+
+	return g.grant(permissions,
+		func(ctx context.Context, roleName string) error {
+			return g.groups.AssignRoleToGroup(ctx, roleName, group)
+		},
+		func(ctx context.Context, roleName string) error {
+			return g.groups.UnassignRoleFromGroup(ctx, roleName, group)
+		},
+	)
+}
+
+func (g *Granter) grant(
+	permissions []perm.Permission,
+	assign func(context.Context, string) error,
+	unassign func(context.Context, string) error,
+) (*Grant, error) {
+	ctx := context.Background()
+	roleName := fmt.Sprintf("grant-%s", uuid.NewV4().String())
+
+	if _, err := g.roles.CreateRole(ctx, roleName, permissions...); err != nil {
+		return nil, err
+	}
+
+	if err := assign(ctx, roleName); err != nil {
+		g.roles.DeleteRole(ctx, roleName)
+		return nil, err
+	}
+
+	grant := &Grant{
+		RoleName:    roleName,
+		Permissions: permissions,
+		roles:       g.roles,
+		unassign: func(ctx context.Context) error {
+			return unassign(ctx, roleName)
+		},
+	}
+
+	if g.cleanups != nil {
+		g.cleanups.Defer(grant.Revoke)
+	}
+
+	return grant, nil
+}
+
+// Revoke unassigns and deletes the role. Only the first call does anything,
+// so it is safe to revoke a grant the cleanups will revoke again.
+func (g *Grant) Revoke() error {
+	g.once.Do(func() {
+		ctx := context.Background()
+
+		unassignErr := g.unassign(ctx)
+		deleteErr := g.roles.DeleteRole(ctx, g.RoleName)
+
+		if unassignErr != nil {
+			g.err = unassignErr
+		} else {
+			g.err = deleteErr
+		}
+	})
+
+	return g.err
+}
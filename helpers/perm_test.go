@@ -0,0 +1,98 @@
+package helpers_test
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/mvcc/helpers"
+	"code.cloudfoundry.org/mvcc/patterns"
+	"code.cloudfoundry.org/mvcc/permx"
+	"code.cloudfoundry.org/perm/pkg/api/protos"
+	"code.cloudfoundry.org/perm/pkg/perm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Granter", func() {
+	var (
+		permServer *permx.PermServer
+		cleanups   helpers.Cleanups
+		granter    *helpers.Granter
+
+		actor    perm.Actor
+		group    perm.Group
+		resource string
+	)
+
+	BeforeEach(func() {
+		permServer = permx.NewPermServer()
+		Expect(permServer.Start()).To(Succeed())
+
+		granter = helpers.NewGranter(permServer.Client(),
+			helpers.WithGroupRoleClient(helpers.NewGroupRoleClient(permServer.Conn())),
+			helpers.WithCleanups(&cleanups),
+		)
+
+		actor = perm.Actor{ID: "actor", Namespace: "namespace"}
+		group = perm.Group{ID: "group"}
+		resource = patterns.Space("org", "space").String()
+	})
+
+	AfterEach(func() {
+		Expect(cleanups.Run()).To(Succeed())
+		Expect(permServer.Close()).To(Succeed())
+	})
+
+	// hasPermission asks perm directly, since perm.Client cannot send groups.
+	hasPermission := func(groups ...perm.Group) bool {
+		req := &protos.HasPermissionRequest{
+			Actor:    &protos.Actor{ID: actor.ID, Namespace: actor.Namespace},
+			Action:   "task.read",
+			Resource: resource,
+		}
+		for _, g := range groups {
+			req.Groups = append(req.Groups, &protos.Group{ID: g.ID})
+		}
+
+		res, err := protos.NewPermissionServiceClient(permServer.Conn()).HasPermission(context.Background(), req)
+		Expect(err).NotTo(HaveOccurred())
+		return res.GetHasPermission()
+	}
+
+	Describe("GrantActor", func() {
+		It("gives the actor the permissions until it is revoked", func() {
+			grant, err := granter.GrantActor(actor, helpers.On(resource, "task.read")...)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hasPermission()).To(BeTrue())
+
+			Expect(grant.Revoke()).To(Succeed())
+			Expect(hasPermission()).To(BeFalse())
+		})
+	})
+
+	Describe("GrantGroup", func() {
+		It("gives the group's members the permissions until it is revoked", func() {
+			grant, err := granter.GrantGroup(group, helpers.On(resource, "task.read")...)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hasPermission(group)).To(BeTrue())
+			Expect(hasPermission()).To(BeFalse())
+
+			Expect(grant.Revoke()).To(Succeed())
+			Expect(hasPermission(group)).To(BeFalse())
+		})
+
+		It("fails without a group role client", func() {
+			_, err := helpers.NewGranter(permServer.Client()).GrantGroup(group, helpers.On(resource, "task.read")...)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Grant.Revoke", func() {
+		It("only revokes once, so the cleanups can run after it", func() {
+			grant, err := granter.GrantActor(actor, helpers.On(resource, "task.read")...)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(grant.Revoke()).To(Succeed())
+			Expect(grant.Revoke()).To(Succeed())
+		})
+	})
+})
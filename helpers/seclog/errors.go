@@ -0,0 +1,12 @@
+package seclog
+
+import "fmt"
+
+type ErrInvalidEvent struct {
+	Line   string
+	Reason string
+}
+
+func (e *ErrInvalidEvent) Error() string {
+	return fmt.Sprintf("invalid CEF event %q: %s", e.Line, e.Reason)
+}
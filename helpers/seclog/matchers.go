@@ -0,0 +1,112 @@
+package seclog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/onsi/gomega/types"
+)
+
+// HaveLogged succeeds if a Source, or a []Event, has an event matching
+// expected, as Event.Matches does. It reads a Source each time it matches,
+// so it can be used with Eventually.
+func HaveLogged(expected Event) types.GomegaMatcher {
+	return &haveLoggedMatcher{expected: expected}
+}
+
+// HaveLoggedPermissionCheck succeeds if perm logged checking whether the
+// actor has the action on the resource pattern.
+func HaveLoggedPermissionCheck(actorID, action, resource string) types.GomegaMatcher {
+	return HaveLogged(Event{
+		Product:   ProductPerm,
+		Signature: "HasPermission",
+		Actor:     actorID,
+		Action:    action,
+		Resource:  resource,
+	})
+}
+
+// HaveLoggedRequest succeeds if the cloud controller logged the actor's
+// request, e.g. "GET /v3/tasks/guid", with the outcome.
+func HaveLoggedRequest(actorID, signature, outcome string) types.GomegaMatcher {
+	return HaveLogged(Event{
+		Product:   ProductCloudController,
+		Signature: signature,
+		Actor:     actorID,
+		Outcome:   outcome,
+	})
+}
+
+type haveLoggedMatcher struct {
+	expected Event
+	events   []Event
+}
+
+func (m *haveLoggedMatcher) Match(actual interface{}) (bool, error) {
+	switch a := actual.(type) {
+	case []Event:
+		m.events = a
+	case Source:
+		events, err := a.Events()
+		if err != nil {
+			return false, err
+		}
+		m.events = events
+	default:
+		return false, fmt.Errorf("HaveLogged expects a seclog.Source or []seclog.Event, got %T", actual)
+	}
+
+	for _, e := range m.events {
+		if e.Matches(m.expected) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *haveLoggedMatcher) FailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected an event matching\n\t%s\namong\n%s", describe(m.expected), list(m.events))
+}
+
+func (m *haveLoggedMatcher) NegatedFailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected no event matching\n\t%s\namong\n%s", describe(m.expected), list(m.events))
+}
+
+func describe(e Event) string {
+	var fields []string
+	add := func(name, value string) {
+		if value != "" {
+			fields = append(fields, fmt.Sprintf("%s=%q", name, value))
+		}
+	}
+
+	add("product", e.Product)
+	add("signature", e.Signature)
+	add("name", e.Name)
+	add("actor", e.Actor)
+	add("action", e.Action)
+	add("resource", e.Resource)
+	add("outcome", e.Outcome)
+	for k, v := range e.Extensions {
+		add(k, v)
+	}
+	for k, v := range e.Custom {
+		add(k, v)
+	}
+
+	return strings.Join(fields, " ")
+}
+
+func list(events []Event) string {
+	if len(events) == 0 {
+		return "\t(no events)"
+	}
+
+	var b strings.Builder
+	for _, e := range events {
+		fmt.Fprintf(&b, "\t%s\n", e.Raw)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,304 @@
+package seclog
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ProductPerm            = "perm"
+	ProductCloudController = "cloud_controller_ng"
+)
+
+// Outcomes the cloud controller logs in its result extension, and the ones
+// perm's authentication events are given.
+const (
+	Success     = "success"
+	ClientError = "clientError"
+	ServerError = "serverError"
+	Failure     = "failure"
+)
+
+// permAuthSucceeded is the msg of perm's successful Auth events.
+const permAuthSucceeded = "authentication succeeded"
+
+// Event is a parsed CEF line.
+type Event struct {
+	Vendor    string
+	Product   string
+	Version   string
+	Signature string
+	Name      string
+	Severity  int
+
+	// Extensions are the key=value pairs, and Custom the custom ones keyed by
+	// their labels, e.g. cs1Label=userID cs1=x is Custom["userID"] = "x".
+	Extensions map[string]string
+	Custom     map[string]string
+
+	// Actor, Action, Resource and Outcome are derived from the rest.
+	//
+	// For perm, Actor is the userID or, for Auth events, the token's subject;
+	// Action is the action checked or else the signature, e.g. AssignRole;
+	// Resource is the resource pattern or the role name. Only Auth events
+	// have an Outcome, Success or Failure.
+	//
+	// For the cloud controller, Actor is the user's GUID, Action the
+	// signature, e.g. "GET /v3/tasks/guid", Resource the request path and
+	// Outcome its result: Success, ClientError or ServerError.
+	Actor    string
+	Action   string
+	Resource string
+	Outcome  string
+
+	Raw string
+}
+
+func (e Event) String() string {
+	return e.Raw
+}
+
+// Matches reports whether the event has every non-empty field of expected,
+// and every extension and custom extension it has.
+func (e Event) Matches(expected Event) bool {
+	fields := []struct{ actual, expected string }{
+		{e.Vendor, expected.Vendor},
+		{e.Product, expected.Product},
+		{e.Version, expected.Version},
+		{e.Signature, expected.Signature},
+		{e.Name, expected.Name},
+		{e.Actor, expected.Actor},
+		{e.Action, expected.Action},
+		{e.Resource, expected.Resource},
+		{e.Outcome, expected.Outcome},
+	}
+	for _, f := range fields {
+		if f.expected != "" && f.actual != f.expected {
+			return false
+		}
+	}
+
+	for k, v := range expected.Extensions {
+		if actual, ok := e.Extensions[k]; !ok || actual != v {
+			return false
+		}
+	}
+	for k, v := range expected.Custom {
+		if actual, ok := e.Custom[k]; !ok || actual != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// extensionKey finds the keys of an extension. Escaped equals signs are
+// preceded by a backslash, which is not a key character.
+var extensionKey = regexp.MustCompile(`(?:^|\s)(\w+)=`)
+
+var extensionUnescaper = strings.NewReplacer(
+	`\\`, `\`,
+	`\=`, `=`,
+	`\n`, "\n",
+	`\r`, "\r",
+)
+
+var prefixUnescaper = strings.NewReplacer(
+	`\\`, `\`,
+	`\|`, `|`,
+	`\n`, "\n",
+)
+
+// Parse parses a CEF line.
+func Parse(line string) (Event, error) {
+	line = strings.TrimRight(line, "\r\n")
+
+	fields, extension, err := splitPrefix(line)
+	if err != nil {
+		return Event{}, err
+	}
+
+	if fields[0] != "CEF:0" {
+		return Event{}, &ErrInvalidEvent{Line: line, Reason: "unsupported CEF version"}
+	}
+
+	severity, err := strconv.Atoi(fields[6])
+	if err != nil {
+		return Event{}, &ErrInvalidEvent{Line: line, Reason: "invalid severity"}
+	}
+
+	e := Event{
+		Vendor:     prefixUnescaper.Replace(fields[1]),
+		Product:    prefixUnescaper.Replace(fields[2]),
+		Version:    prefixUnescaper.Replace(fields[3]),
+		Signature:  prefixUnescaper.Replace(fields[4]),
+		Name:       prefixUnescaper.Replace(fields[5]),
+		Severity:   severity,
+		Extensions: parseExtension(extension),
+		Custom:     map[string]string{},
+		Raw:        line,
+	}
+
+	for k, label := range e.Extensions {
+		if !strings.HasSuffix(k, "Label") {
+			continue
+		}
+		if v, ok := e.Extensions[strings.TrimSuffix(k, "Label")]; ok {
+			e.Custom[label] = v
+		}
+	}
+
+	e.derive()
+
+	return e, nil
+}
+
+// splitPrefix splits the line at the first seven unescaped pipes.
+func splitPrefix(line string) ([]string, string, error) {
+	var fields []string
+
+	start := 0
+	for i := 0; i < len(line) && len(fields) < 7; i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			fields = append(fields, line[start:i])
+			start = i + 1
+		}
+	}
+
+	if len(fields) < 7 {
+		return nil, "", &ErrInvalidEvent{Line: line, Reason: "too few fields"}
+	}
+
+	return fields, line[start:], nil
+}
+
+func parseExtension(extension string) map[string]string {
+	pairs := map[string]string{}
+
+	matches := extensionKey.FindAllStringSubmatchIndex(extension, -1)
+	for i, m := range matches {
+		end := len(extension)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+
+		key := extension[m[2]:m[3]]
+		pairs[key] = extensionUnescaper.Replace(strings.TrimSpace(extension[m[1]:end]))
+	}
+
+	return pairs
+}
+
+func (e *Event) derive() {
+	e.Actor = first(e.Custom["userID"], e.Custom["subject"], e.Extensions["suid"])
+	e.Action = first(e.Custom["action"], e.Signature)
+	e.Resource = first(e.Custom["resource"], e.Custom["roleName"], e.Extensions["request"])
+
+	switch {
+	case e.Custom["result"] != "":
+		e.Outcome = e.Custom["result"]
+	case e.Product == ProductPerm && e.Signature == "Auth":
+		e.Outcome = Failure
+		if e.Custom["msg"] == permAuthSucceeded {
+			e.Outcome = Success
+		}
+	}
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Read parses a CEF line per line of r, skipping blank lines.
+func Read(r io.Reader) ([]Event, error) {
+	var events []Event
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		e, err := Parse(line)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+
+	return events, scanner.Err()
+}
+
+// ReadFile reads the events in the file. A file that does not exist yet has
+// none, since the cloud controller only creates it once it logs.
+func ReadFile(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// Source is somewhere events are logged.
+type Source interface {
+	Events() ([]Event, error)
+}
+
+// File is a log file, such as the cloud controller's security event log.
+type File string
+
+func (f File) Events() ([]Event, error) {
+	return ReadFile(string(f))
+}
+
+// Recorder keeps the lines written to it, e.g. by perm's CEF logger. It is
+// safe for concurrent use.
+type Recorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.buf.Write(p)
+}
+
+// Events parses the lines written so far.
+func (r *Recorder) Events() ([]Event, error) {
+	r.mu.Lock()
+	lines := r.buf.String()
+	r.mu.Unlock()
+
+	return Read(strings.NewReader(lines))
+}
+
+// Reset forgets the lines written so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf.Reset()
+}
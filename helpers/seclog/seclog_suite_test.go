@@ -0,0 +1,13 @@
+package seclog_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSeclog(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Seclog Suite")
+}
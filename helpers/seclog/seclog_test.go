@@ -0,0 +1,175 @@
+package seclog_test
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/mvcc/helpers/seclog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parse", func() {
+	It("parses the prefix", func() {
+		e, err := seclog.Parse("CEF:0|cloud_foundry|perm|0.0.0|Auth|Authentication|3|suser=bob\n")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(e.Vendor).To(Equal("cloud_foundry"))
+		Expect(e.Product).To(Equal(seclog.ProductPerm))
+		Expect(e.Version).To(Equal("0.0.0"))
+		Expect(e.Signature).To(Equal("Auth"))
+		Expect(e.Name).To(Equal("Authentication"))
+		Expect(e.Severity).To(Equal(3))
+		Expect(e.Raw).To(Equal("CEF:0|cloud_foundry|perm|0.0.0|Auth|Authentication|3|suser=bob"))
+	})
+
+	escaped := []struct {
+		description string
+		line        string
+		field       func(seclog.Event) string
+		value       string
+	}{
+		{"pipes in the prefix", `CEF:0|cloud\|foundry|perm|0|Auth|Auth|0|`, func(e seclog.Event) string { return e.Vendor }, "cloud|foundry"},
+		{"backslashes in the prefix", `CEF:0|cf|perm|0|Auth|a\\b|0|`, func(e seclog.Event) string { return e.Name }, `a\b`},
+		{"a backslash before a pipe in the prefix", `CEF:0|cf|perm|0|Auth|a\\|0|`, func(e seclog.Event) string { return e.Name }, `a\`},
+		{"pipes in the extension", `CEF:0|cf|perm|0|Auth|Auth|0|msg=a|b`, func(e seclog.Event) string { return e.Extensions["msg"] }, "a|b"},
+		{"equals signs in the extension", `CEF:0|cf|perm|0|Auth|Auth|0|msg=a\=b`, func(e seclog.Event) string { return e.Extensions["msg"] }, "a=b"},
+		{"equals signs after a space in the extension", `CEF:0|cf|perm|0|Auth|Auth|0|msg=a b\=c`, func(e seclog.Event) string { return e.Extensions["msg"] }, "a b=c"},
+		{"backslashes in the extension", `CEF:0|cf|perm|0|Auth|Auth|0|msg=a\\b`, func(e seclog.Event) string { return e.Extensions["msg"] }, `a\b`},
+		{"newlines in the extension", `CEF:0|cf|perm|0|Auth|Auth|0|msg=a\nb`, func(e seclog.Event) string { return e.Extensions["msg"] }, "a\nb"},
+	}
+
+	for _, c := range escaped {
+		c := c
+
+		It("unescapes "+c.description, func() {
+			e, err := seclog.Parse(c.line)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.field(e)).To(Equal(c.value))
+		})
+	}
+
+	It("splits the extension at each key", func() {
+		e, err := seclog.Parse("CEF:0|cf|cloud_controller_ng|2|GET /v2/apps|GET /v2/apps|0|rt=1 suid=u-1 request=/v2/apps?q=name:a b")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(e.Extensions).To(Equal(map[string]string{
+			"rt":      "1",
+			"suid":    "u-1",
+			"request": "/v2/apps?q=name:a b",
+		}))
+	})
+
+	Describe("custom extensions", func() {
+		It("pairs each value with its label", func() {
+			e, err := seclog.Parse("CEF:0|cf|perm|0|HasPermission|HasPermission|1|cs1Label=userID cs1=u-1 cs2Label=action cs2=task.read cs3=unlabelled")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e.Custom).To(Equal(map[string]string{
+				"userID": "u-1",
+				"action": "task.read",
+			}))
+		})
+
+		It("pairs them whichever comes first", func() {
+			e, err := seclog.Parse("CEF:0|cf|perm|0|HasPermission|HasPermission|1|cs1=u-1 cs1Label=userID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e.Custom).To(Equal(map[string]string{"userID": "u-1"}))
+		})
+
+		It("skips labels without a value", func() {
+			e, err := seclog.Parse("CEF:0|cf|perm|0|HasPermission|HasPermission|1|cs1Label=userID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e.Custom).To(BeEmpty())
+		})
+	})
+
+	Describe("derived fields", func() {
+		It("derives them from perm's permission checks", func() {
+			e, err := seclog.Parse("CEF:0|cf|perm|0|HasPermission|HasPermission|1|cs1Label=userID cs1=u-1 cs2Label=action cs2=task.read cs3Label=resource cs3=o/s")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e.Actor).To(Equal("u-1"))
+			Expect(e.Action).To(Equal("task.read"))
+			Expect(e.Resource).To(Equal("o/s"))
+			Expect(e.Outcome).To(BeEmpty())
+		})
+
+		It("gives perm's Auth events an outcome", func() {
+			succeeded, err := seclog.Parse("CEF:0|cf|perm|0|Auth|Auth|1|cs1Label=subject cs1=u-1 cs2Label=msg cs2=authentication succeeded")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(succeeded.Actor).To(Equal("u-1"))
+			Expect(succeeded.Outcome).To(Equal(seclog.Success))
+
+			failed, err := seclog.Parse("CEF:0|cf|perm|0|Auth|Auth|1|cs1Label=subject cs1=u-1 cs2Label=msg cs2=token expired")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(failed.Outcome).To(Equal(seclog.Failure))
+		})
+
+		It("derives them from the cloud controller's requests", func() {
+			e, err := seclog.Parse("CEF:0|cloud_foundry|cloud_controller_ng|2|GET /v3/tasks/t|GET /v3/tasks/t|0|suid=u-1 request=/v3/tasks/t cs1Label=result cs1=clientError")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e.Actor).To(Equal("u-1"))
+			Expect(e.Action).To(Equal("GET /v3/tasks/t"))
+			Expect(e.Resource).To(Equal("/v3/tasks/t"))
+			Expect(e.Outcome).To(Equal(seclog.ClientError))
+		})
+	})
+
+	invalid := []struct {
+		line   string
+		reason string
+	}{
+		{"", "too few fields"},
+		{"CEF:0|cf|perm|0|Auth|Auth", "too few fields"},
+		{`CEF:0|cf|perm|0|Auth|Auth\|3|`, "too few fields"},
+		{"CEF:1|cf|perm|0|Auth|Auth|3|", "unsupported CEF version"},
+		{"CEF:0|cf|perm|0|Auth|Auth|high|", "invalid severity"},
+	}
+
+	for _, c := range invalid {
+		c := c
+
+		It(fmt.Sprintf("rejects %q", c.line), func() {
+			_, err := seclog.Parse(c.line)
+			Expect(err).To(Equal(&seclog.ErrInvalidEvent{Line: c.line, Reason: c.reason}))
+		})
+	}
+})
+
+var _ = Describe("Read", func() {
+	It("parses each line, skipping blank ones", func() {
+		events, err := seclog.Read(strings.NewReader("CEF:0|cf|perm|0|Auth|Auth|1|\n\n  \nCEF:0|cf|perm|0|AssignRole|AssignRole|1|\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Signature).To(Equal("Auth"))
+		Expect(events[1].Signature).To(Equal("AssignRole"))
+	})
+
+	It("returns the events before an invalid line", func() {
+		events, err := seclog.Read(strings.NewReader("CEF:0|cf|perm|0|Auth|Auth|1|\nnot CEF\n"))
+		Expect(err).To(BeAssignableToTypeOf(&seclog.ErrInvalidEvent{}))
+		Expect(events).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Event", func() {
+	Describe("Matches", func() {
+		e := seclog.Event{
+			Product:    seclog.ProductPerm,
+			Signature:  "HasPermission",
+			Actor:      "u-1",
+			Extensions: map[string]string{"rt": "1"},
+			Custom:     map[string]string{"action": "task.read"},
+		}
+
+		It("matches the fields it has", func() {
+			Expect(e.Matches(seclog.Event{})).To(BeTrue())
+			Expect(e.Matches(seclog.Event{Product: seclog.ProductPerm, Actor: "u-1"})).To(BeTrue())
+			Expect(e.Matches(seclog.Event{Custom: map[string]string{"action": "task.read"}})).To(BeTrue())
+		})
+
+		It("does not match other fields", func() {
+			Expect(e.Matches(seclog.Event{Actor: "u-2"})).To(BeFalse())
+			Expect(e.Matches(seclog.Event{Extensions: map[string]string{"rt": "2"}})).To(BeFalse())
+			Expect(e.Matches(seclog.Event{Custom: map[string]string{"resource": "o/s"}})).To(BeFalse())
+		})
+	})
+})
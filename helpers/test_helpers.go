@@ -1,11 +1,17 @@
 package helpers
 
-import "fmt"
+import "code.cloudfoundry.org/mvcc/patterns"
 
 func OrgResourceID(orgUUID string) string {
-	return fmt.Sprintf("%s/*", orgUUID)
+	return patterns.Org(orgUUID).String()
 }
 
 func SpaceResourceID(orgUUID, spaceUUID string) string {
-	return fmt.Sprintf("%s/%s", orgUUID, spaceUUID)
+	return patterns.Space(orgUUID, spaceUUID).String()
+}
+
+// AppResourceID is patterns.App's "<org>/<space>/app/<app>". The cloud
+// controller itself only checks org and space patterns.
+func AppResourceID(orgUUID, spaceUUID, appUUID string) string {
+	return patterns.App(orgUUID, spaceUUID, appUUID).String()
 }
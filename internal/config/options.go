@@ -33,6 +33,15 @@ func WithPermCACertPath(caPath string) Option {
 	}
 }
 
+// WithPermClient is the UAA client the cloud controller gets tokens for perm
+// as, for perm servers that authenticate their callers.
+func WithPermClient(name, secret string) Option {
+	return func(c *config) {
+		c.Perm.UAAClientName = name
+		c.Perm.UAAClientSecret = secret
+	}
+}
+
 func WithPermTimeoutInMilliseconds(timeout int) Option {
 	return func(c *config) {
 		c.Perm.TimeoutInMilliseconds = timeout
@@ -51,6 +60,91 @@ func WithUAAInternalURL(internalURL string) Option {
 	}
 }
 
+func WithUAASymmetricSecret(secret string) Option {
+	return func(c *config) {
+		c.UAA.SymmetricSecret = secret
+	}
+}
+
+func WithUsernameLookupClient(name, secret string) Option {
+	return func(c *config) {
+		c.CloudControllerUsernameLookupClientName = name
+		c.CloudControllerUsernameLookupClientSecret = secret
+	}
+}
+
+// WithInternalAPI sets the basic auth credentials of the cloud controller's
+// internal API, which Diego components report crashes to.
+func WithInternalAPI(user, password string) Option {
+	return func(c *config) {
+		c.InternalAPI.AuthUser = user
+		c.InternalAPI.AuthPassword = password
+	}
+}
+
+// WithSecurityEventLogging makes the cloud controller log a CEF line per
+// request to the file.
+func WithSecurityEventLogging(file string) Option {
+	return func(c *config) {
+		c.SecurityEventLogging.Enabled = true
+		c.SecurityEventLogging.File = file
+	}
+}
+
+func WithDatabaseAdapter(adapter string) Option {
+	return func(c *config) {
+		c.DB.DatabaseParts.Adapter = adapter
+	}
+}
+
+func WithDatabaseHost(host string) Option {
+	return func(c *config) {
+		c.DB.DatabaseParts.Host = host
+	}
+}
+
+func WithDatabasePort(port int) Option {
+	return func(c *config) {
+		c.DB.DatabaseParts.Port = port
+	}
+}
+
+func WithDatabaseUser(user string) Option {
+	return func(c *config) {
+		c.DB.DatabaseParts.User = user
+	}
+}
+
+func WithDatabasePassword(password string) Option {
+	return func(c *config) {
+		c.DB.DatabaseParts.Password = password
+	}
+}
+
+func WithDatabaseMaxConnections(max int) Option {
+	return func(c *config) {
+		c.DB.MaxConnections = max
+	}
+}
+
+func WithDatabaseLogLevel(level string) Option {
+	return func(c *config) {
+		c.DB.LogLevel = level
+	}
+}
+
+func WithDatabaseQueryLogging(enabled bool) Option {
+	return func(c *config) {
+		c.DB.LogDbQueries = enabled
+	}
+}
+
+func WithDatabaseName(name string) Option {
+	return func(c *config) {
+		c.DB.DatabaseParts.Database = name
+	}
+}
+
 func WithBBSURL(url string) Option {
 	return func(c *config) {
 		c.Diego.BBS.URL = url
@@ -114,7 +208,7 @@ type config struct {
 		URL             string `yaml:"url"`
 		InternalURL     string `yaml:"internal_url"`
 		ResourceID      string `yaml:"resource_id"`
-		SymmetricSecret string `yaml:"symmetric_secret"`
+		SymmetricSecret string `yaml:"symmetric_secret,omitempty"`
 		CAFile          string `yaml:"ca_file"`
 		ClientTimeout   int    `yaml:"client_timeout"`
 	} `yaml:"uaa"`
@@ -261,6 +355,8 @@ type config struct {
 		Port                  int    `yaml:"port"`
 		CACertPath            string `yaml:"ca_cert_path"`
 		TimeoutInMilliseconds int    `yaml:"timeout_in_milliseconds"`
+		UAAClientName         string `yaml:"uaa_client_name,omitempty"`
+		UAAClientSecret       string `yaml:"uaa_client_secret,omitempty"`
 	} `yaml:"perm"`
 }
 
@@ -314,8 +410,6 @@ func defaultConfig() *config {
 	c.Diego.BBS.URL = "http://localhost:8889"
 
 	c.InternalServiceHostname = "localhost"
-	c.InternalAPI.AuthUser = "user"
-	c.InternalAPI.AuthPassword = "password"
 
 	c.Droplets.MaxStagedDropletsStored = 100000
 	c.Packages.MaxValidPackagesStored = 100000
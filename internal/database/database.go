@@ -0,0 +1,202 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	Postgres = "postgres"
+	MySQL    = "mysql"
+)
+
+var ErrUnsupportedAdapter = errors.New("unsupported database adapter")
+
+// Server is where the cloud controller's databases live.
+type Server struct {
+	Adapter  string
+	Host     string
+	Port     int
+	User     string
+	Password string
+}
+
+// UniqueName returns a database name no other call returns.
+func UniqueName(prefix string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return prefix + "_" + hex.EncodeToString(b), nil
+}
+
+// Clone creates the database name as a copy of template, schema and rows.
+func Clone(server Server, template, name string) error {
+	switch server.Adapter {
+	case Postgres:
+		return psql(server, fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", quotePostgres(name), quotePostgres(template)))
+	case MySQL:
+		return cloneMySQL(server, template, name)
+	default:
+		return ErrUnsupportedAdapter
+	}
+}
+
+// Drop drops the database, if it exists.
+func Drop(server Server, name string) error {
+	switch server.Adapter {
+	case Postgres:
+		return psql(server, fmt.Sprintf("DROP DATABASE IF EXISTS %s", quotePostgres(name)))
+	case MySQL:
+		db, err := openMySQL(server)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		_, err = db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", quoteMySQL(name)))
+		return err
+	default:
+		return ErrUnsupportedAdapter
+	}
+}
+
+// psql runs the statement against the maintenance database. No Postgres
+// driver is vendored, so it shells out to psql, which must be on the PATH.
+func psql(server Server, statement string) error {
+	cmd := exec.Command("psql",
+		"--no-psqlrc",
+		"--quiet",
+		"--set", "ON_ERROR_STOP=1",
+		"--host", server.Host,
+		"--port", strconv.Itoa(server.Port),
+		"--username", server.User,
+		"--dbname", "postgres",
+		"--command", statement,
+	)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+server.Password)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("psql: %s: %s", err, msg)
+		}
+		return fmt.Errorf("psql: %s", err)
+	}
+
+	return nil
+}
+
+func openMySQL(server Server) (*sql.DB, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = server.User
+	cfg.Passwd = server.Password
+	cfg.Net = "tcp"
+	cfg.Addr = fmt.Sprintf("%s:%d", server.Host, server.Port)
+
+	return sql.Open("mysql", cfg.FormatDSN())
+}
+
+// cloneMySQL copies the template's tables one by one, since MySQL has no
+// template databases. Foreign key checks are off on the connection so the
+// tables can be created and filled in any order. The new database is dropped
+// again if any table fails to copy.
+func cloneMySQL(server Server, template, name string) (err error) {
+	db, err := openMySQL(server)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	tables, err := mysqlTables(ctx, conn, template)
+	if err != nil {
+		return err
+	}
+
+	if _, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+
+	if _, err = conn.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE %s", quoteMySQL(name))); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			conn.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s", quoteMySQL(name)))
+		}
+	}()
+
+	if _, err = conn.ExecContext(ctx, fmt.Sprintf("USE %s", quoteMySQL(name))); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		var tableName, create string
+		err = conn.QueryRowContext(ctx, fmt.Sprintf("SHOW CREATE TABLE %s.%s", quoteMySQL(template), quoteMySQL(table))).Scan(&tableName, &create)
+		if err != nil {
+			return err
+		}
+
+		if _, err = conn.ExecContext(ctx, create); err != nil {
+			return err
+		}
+
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s SELECT * FROM %s.%s", quoteMySQL(table), quoteMySQL(template), quoteMySQL(table)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mysqlTables(ctx context.Context, conn *sql.Conn, schema string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = ? AND table_type = 'BASE TABLE'",
+		schema,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, rows.Err()
+}
+
+func quotePostgres(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
+func quoteMySQL(identifier string) string {
+	return "`" + strings.Replace(identifier, "`", "``", -1) + "`"
+}
@@ -0,0 +1 @@
+package database // import "code.cloudfoundry.org/mvcc/internal/database"
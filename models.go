@@ -39,6 +39,12 @@ type Package struct {
 	State string
 }
 
+type DockerImage struct {
+	Ref      string
+	Username string
+	Password string
+}
+
 type Build struct {
 	UUID        string
 	State       string
@@ -46,7 +52,14 @@ type Build struct {
 }
 
 type Task struct {
-	UUID string
+	UUID  string
+	State string
+}
+
+type Event struct {
+	UUID      string
+	Type      string
+	ActeeUUID string
 }
 
 func RandomUUID(prefix string) string {
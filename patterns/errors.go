@@ -0,0 +1,12 @@
+package patterns
+
+import "fmt"
+
+type ErrInvalidPattern struct {
+	Pattern string
+	Reason  string
+}
+
+func (e *ErrInvalidPattern) Error() string {
+	return fmt.Sprintf("invalid resource pattern %q: %s", e.Pattern, e.Reason)
+}
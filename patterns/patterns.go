@@ -0,0 +1,268 @@
+package patterns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Wildcard stands for every resource of a kind in its parent.
+const Wildcard = "*"
+
+type Kind string
+
+const (
+	KindOrg   Kind = "org"
+	KindSpace Kind = "space"
+
+	// Resources that belong to a space.
+	KindApp             Kind = "app"
+	KindRoute           Kind = "route"
+	KindServiceInstance Kind = "service_instance"
+
+	// Resources that belong to an app.
+	KindTask    Kind = "task"
+	KindProcess Kind = "process"
+	KindDroplet Kind = "droplet"
+	KindPackage Kind = "package"
+	KindBuild   Kind = "build"
+)
+
+var (
+	spaceKinds = []Kind{KindApp, KindRoute, KindServiceInstance}
+	appKinds   = []Kind{KindTask, KindProcess, KindDroplet, KindPackage, KindBuild}
+)
+
+func (k Kind) inSpace() bool {
+	return containsKind(spaceKinds, k)
+}
+
+func (k Kind) inApp() bool {
+	return containsKind(appKinds, k)
+}
+
+// Pattern is a perm resource pattern. Orgs are "<org>/*" and spaces
+// "<org>/<space>", which is what the cloud controller checks; resources in a
+// space add "/<kind>/<id>" and resources of an app add
+// "/app/<app>/<kind>/<id>" to their space's pattern.
+//
+// ID is the resource's own GUID, or Wildcard for every resource of the kind.
+// Orgs and spaces have no ID, and App is only set for resources of an app.
+type Pattern struct {
+	Kind  Kind
+	Org   string
+	Space string
+	App   string
+	ID    string
+}
+
+func Org(org string) Pattern {
+	return Pattern{Kind: KindOrg, Org: org}
+}
+
+func Space(org, space string) Pattern {
+	return Pattern{Kind: KindSpace, Org: org, Space: space}
+}
+
+func App(org, space, app string) Pattern {
+	return Pattern{Kind: KindApp, Org: org, Space: space, ID: app}
+}
+
+func AllApps(org, space string) Pattern {
+	return App(org, space, Wildcard)
+}
+
+func Route(org, space, route string) Pattern {
+	return Pattern{Kind: KindRoute, Org: org, Space: space, ID: route}
+}
+
+func AllRoutes(org, space string) Pattern {
+	return Route(org, space, Wildcard)
+}
+
+func ServiceInstance(org, space, serviceInstance string) Pattern {
+	return Pattern{Kind: KindServiceInstance, Org: org, Space: space, ID: serviceInstance}
+}
+
+func AllServiceInstances(org, space string) Pattern {
+	return ServiceInstance(org, space, Wildcard)
+}
+
+func Task(org, space, app, task string) Pattern {
+	return Pattern{Kind: KindTask, Org: org, Space: space, App: app, ID: task}
+}
+
+func AllTasks(org, space, app string) Pattern {
+	return Task(org, space, app, Wildcard)
+}
+
+func Process(org, space, app, process string) Pattern {
+	return Pattern{Kind: KindProcess, Org: org, Space: space, App: app, ID: process}
+}
+
+func AllProcesses(org, space, app string) Pattern {
+	return Process(org, space, app, Wildcard)
+}
+
+func Droplet(org, space, app, droplet string) Pattern {
+	return Pattern{Kind: KindDroplet, Org: org, Space: space, App: app, ID: droplet}
+}
+
+func AllDroplets(org, space, app string) Pattern {
+	return Droplet(org, space, app, Wildcard)
+}
+
+func Package(org, space, app, pkg string) Pattern {
+	return Pattern{Kind: KindPackage, Org: org, Space: space, App: app, ID: pkg}
+}
+
+func AllPackages(org, space, app string) Pattern {
+	return Package(org, space, app, Wildcard)
+}
+
+func Build(org, space, app, build string) Pattern {
+	return Pattern{Kind: KindBuild, Org: org, Space: space, App: app, ID: build}
+}
+
+func AllBuilds(org, space, app string) Pattern {
+	return Build(org, space, app, Wildcard)
+}
+
+func (p Pattern) String() string {
+	switch {
+	case p.Kind == KindOrg:
+		return fmt.Sprintf("%s/%s", p.Org, Wildcard)
+	case p.Kind == KindSpace:
+		return fmt.Sprintf("%s/%s", p.Org, p.Space)
+	case p.Kind.inApp():
+		return fmt.Sprintf("%s/%s/%s/%s/%s/%s", p.Org, p.Space, KindApp, p.App, p.Kind, p.ID)
+	default:
+		return fmt.Sprintf("%s/%s/%s/%s", p.Org, p.Space, p.Kind, p.ID)
+	}
+}
+
+// Validate checks that the pattern has the GUIDs its kind needs and no
+// others, and that only its ID is a wildcard.
+func (p Pattern) Validate() error {
+	invalid := func(reason string) error {
+		return &ErrInvalidPattern{Pattern: p.String(), Reason: reason}
+	}
+
+	org := field{"Org", p.Org}
+	space := field{"Space", p.Space}
+	app := field{"App", p.App}
+	id := field{"ID", p.ID}
+
+	var required, unused []field
+	switch {
+	case p.Kind == KindOrg:
+		required, unused = []field{org}, []field{space, app, id}
+	case p.Kind == KindSpace:
+		required, unused = []field{org, space}, []field{app, id}
+	case p.Kind.inSpace():
+		required, unused = []field{org, space}, []field{app}
+	case p.Kind.inApp():
+		required = []field{org, space, app}
+	default:
+		return invalid(fmt.Sprintf("unknown kind %q", p.Kind))
+	}
+
+	for _, f := range required {
+		switch {
+		case f.guid == "":
+			return invalid(fmt.Sprintf("%s patterns need %s", p.Kind, f.name))
+		case f.guid == Wildcard:
+			return invalid(fmt.Sprintf("%s cannot be a wildcard", f.name))
+		case strings.Contains(f.guid, "/") || strings.Contains(f.guid, Wildcard):
+			return invalid(fmt.Sprintf("%s %q contains a / or *", f.name, f.guid))
+		}
+	}
+
+	for _, f := range unused {
+		if f.guid != "" {
+			return invalid(fmt.Sprintf("%s patterns cannot set %s", p.Kind, f.name))
+		}
+	}
+
+	if p.Kind != KindOrg && p.Kind != KindSpace {
+		switch {
+		case p.ID == "":
+			return invalid(fmt.Sprintf("%s patterns need an ID or a wildcard", p.Kind))
+		case p.ID != Wildcard && (strings.Contains(p.ID, "/") || strings.Contains(p.ID, Wildcard)):
+			return invalid(fmt.Sprintf("ID %q contains a / or *", p.ID))
+		}
+	}
+
+	return nil
+}
+
+// IsWildcard reports whether the pattern covers every resource of its kind
+// in its parent. Org patterns cover everything in the org.
+func (p Pattern) IsWildcard() bool {
+	return p.Kind == KindOrg || p.ID == Wildcard
+}
+
+// Parse is the inverse of String: Parse(p.String()) is p for every valid p.
+func Parse(pattern string) (Pattern, error) {
+	invalid := func(reason string) (Pattern, error) {
+		return Pattern{}, &ErrInvalidPattern{Pattern: pattern, Reason: reason}
+	}
+
+	var p Pattern
+	segments := strings.Split(pattern, "/")
+	switch len(segments) {
+	case 2:
+		if segments[1] == Wildcard {
+			p = Org(segments[0])
+		} else {
+			p = Space(segments[0], segments[1])
+		}
+	case 4:
+		kind := Kind(segments[2])
+		if !kind.inSpace() {
+			return invalid(fmt.Sprintf("%q is not a kind of resource in a space", kind))
+		}
+		p = Pattern{Kind: kind, Org: segments[0], Space: segments[1], ID: segments[3]}
+	case 6:
+		if Kind(segments[2]) != KindApp {
+			return invalid(fmt.Sprintf("expected %q, got %q", KindApp, segments[2]))
+		}
+		kind := Kind(segments[4])
+		if !kind.inApp() {
+			return invalid(fmt.Sprintf("%q is not a kind of resource of an app", kind))
+		}
+		p = Pattern{Kind: kind, Org: segments[0], Space: segments[1], App: segments[3], ID: segments[5]}
+	default:
+		return invalid(fmt.Sprintf("has %d segments, expected 2, 4 or 6", len(segments)))
+	}
+
+	if err := p.Validate(); err != nil {
+		err.(*ErrInvalidPattern).Pattern = pattern
+		return Pattern{}, err
+	}
+
+	return p, nil
+}
+
+// MustParse is Parse for patterns known to be valid. It panics otherwise.
+func MustParse(pattern string) Pattern {
+	p, err := Parse(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+type field struct {
+	name string
+	guid string
+}
+
+func containsKind(kinds []Kind, k Kind) bool {
+	for _, kind := range kinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
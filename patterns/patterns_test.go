@@ -0,0 +1,142 @@
+package patterns_test
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/mvcc/patterns"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pattern", func() {
+	valid := []struct {
+		pattern patterns.Pattern
+		str     string
+	}{
+		{patterns.Org("o"), "o/*"},
+		{patterns.Space("o", "s"), "o/s"},
+
+		{patterns.App("o", "s", "a"), "o/s/app/a"},
+		{patterns.Route("o", "s", "r"), "o/s/route/r"},
+		{patterns.ServiceInstance("o", "s", "si"), "o/s/service_instance/si"},
+
+		{patterns.Task("o", "s", "a", "t"), "o/s/app/a/task/t"},
+		{patterns.Process("o", "s", "a", "p"), "o/s/app/a/process/p"},
+		{patterns.Droplet("o", "s", "a", "d"), "o/s/app/a/droplet/d"},
+		{patterns.Package("o", "s", "a", "p"), "o/s/app/a/package/p"},
+		{patterns.Build("o", "s", "a", "b"), "o/s/app/a/build/b"},
+
+		{patterns.AllApps("o", "s"), "o/s/app/*"},
+		{patterns.AllRoutes("o", "s"), "o/s/route/*"},
+		{patterns.AllServiceInstances("o", "s"), "o/s/service_instance/*"},
+		{patterns.AllTasks("o", "s", "a"), "o/s/app/a/task/*"},
+		{patterns.AllProcesses("o", "s", "a"), "o/s/app/a/process/*"},
+		{patterns.AllDroplets("o", "s", "a"), "o/s/app/a/droplet/*"},
+		{patterns.AllPackages("o", "s", "a"), "o/s/app/a/package/*"},
+		{patterns.AllBuilds("o", "s", "a"), "o/s/app/a/build/*"},
+	}
+
+	for _, c := range valid {
+		c := c
+
+		Describe(c.str, func() {
+			It("is valid", func() {
+				Expect(c.pattern.Validate()).To(Succeed())
+			})
+
+			It("formats as "+c.str, func() {
+				Expect(c.pattern.String()).To(Equal(c.str))
+			})
+
+			It("parses back to the pattern", func() {
+				Expect(patterns.Parse(c.pattern.String())).To(Equal(c.pattern))
+			})
+		})
+	}
+
+	Describe("IsWildcard", func() {
+		It("is true for orgs and wildcard IDs", func() {
+			Expect(patterns.Org("o").IsWildcard()).To(BeTrue())
+			Expect(patterns.AllApps("o", "s").IsWildcard()).To(BeTrue())
+			Expect(patterns.AllTasks("o", "s", "a").IsWildcard()).To(BeTrue())
+		})
+
+		It("is false for spaces and single resources", func() {
+			Expect(patterns.Space("o", "s").IsWildcard()).To(BeFalse())
+			Expect(patterns.App("o", "s", "a").IsWildcard()).To(BeFalse())
+			Expect(patterns.Task("o", "s", "a", "t").IsWildcard()).To(BeFalse())
+		})
+	})
+
+	Describe("Validate", func() {
+		invalid := []struct {
+			description string
+			pattern     patterns.Pattern
+			reason      string
+		}{
+			{"an unknown kind", patterns.Pattern{Kind: "user", Org: "o", Space: "s", ID: "u"}, `unknown kind "user"`},
+			{"an org without an org", patterns.Org(""), "org patterns need Org"},
+			{"an org with a space", patterns.Pattern{Kind: patterns.KindOrg, Org: "o", Space: "s"}, "org patterns cannot set Space"},
+			{"an org with an ID", patterns.Pattern{Kind: patterns.KindOrg, Org: "o", ID: "i"}, "org patterns cannot set ID"},
+			{"a space without a space", patterns.Space("o", ""), "space patterns need Space"},
+			{"a space with an app", patterns.Pattern{Kind: patterns.KindSpace, Org: "o", Space: "s", App: "a"}, "space patterns cannot set App"},
+			{"a wildcard org", patterns.Space("*", "s"), "Org cannot be a wildcard"},
+			{"a wildcard space", patterns.AllApps("o", "*"), "Space cannot be a wildcard"},
+			{"a wildcard app", patterns.AllTasks("o", "s", "*"), "App cannot be a wildcard"},
+			{"a GUID with a /", patterns.Space("o", "s/t"), `Space "s/t" contains a / or *`},
+			{"a GUID with a *", patterns.Space("o*", "s"), `Org "o*" contains a / or *`},
+			{"a resource in a space with an app", patterns.Pattern{Kind: patterns.KindRoute, Org: "o", Space: "s", App: "a", ID: "r"}, "route patterns cannot set App"},
+			{"a resource of an app without an app", patterns.Task("o", "s", "", "t"), "task patterns need App"},
+			{"a resource without an ID", patterns.App("o", "s", ""), "app patterns need an ID or a wildcard"},
+			{"an ID with a /", patterns.App("o", "s", "a/b"), `ID "a/b" contains a / or *`},
+			{"an ID with a *", patterns.Task("o", "s", "a", "t*"), `ID "t*" contains a / or *`},
+		}
+
+		for _, c := range invalid {
+			c := c
+
+			It("rejects "+c.description, func() {
+				err := c.pattern.Validate()
+				Expect(err).To(BeAssignableToTypeOf(&patterns.ErrInvalidPattern{}))
+				Expect(err.(*patterns.ErrInvalidPattern).Reason).To(Equal(c.reason))
+			})
+		}
+	})
+
+	Describe("Parse", func() {
+		malformed := []struct {
+			pattern string
+			reason  string
+		}{
+			{"", "has 1 segments, expected 2, 4 or 6"},
+			{"o", "has 1 segments, expected 2, 4 or 6"},
+			{"o/s/app", "has 3 segments, expected 2, 4 or 6"},
+			{"o/s/app/a/task", "has 5 segments, expected 2, 4 or 6"},
+			{"o/s/app/a/task/t/x", "has 7 segments, expected 2, 4 or 6"},
+			{"o/s/task/t", `"task" is not a kind of resource in a space`},
+			{"o/s/user/u", `"user" is not a kind of resource in a space`},
+			{"o/s/route/r/task/t", `expected "app", got "route"`},
+			{"o/s/app/a/route/r", `"route" is not a kind of resource of an app`},
+			{"/*", "org patterns need Org"},
+			{"*/*", "Org cannot be a wildcard"},
+			{"o/", "space patterns need Space"},
+			{"o/s/app/", "app patterns need an ID or a wildcard"},
+			{"o/*/app/a", "Space cannot be a wildcard"},
+			{"o/s/app/*/task/t", "App cannot be a wildcard"},
+			{"o/s/app/a/task/t*", `ID "t*" contains a / or *`},
+		}
+
+		for _, c := range malformed {
+			c := c
+
+			It(fmt.Sprintf("rejects %q", c.pattern), func() {
+				_, err := patterns.Parse(c.pattern)
+				Expect(err).To(Equal(&patterns.ErrInvalidPattern{Pattern: c.pattern, Reason: c.reason}))
+			})
+		}
+
+		It("panics in MustParse", func() {
+			Expect(func() { patterns.MustParse("o/s/app") }).To(Panic())
+		})
+	})
+})
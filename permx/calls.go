@@ -0,0 +1,144 @@
+package permx
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/perm/pkg/api/protos"
+	"code.cloudfoundry.org/perm/pkg/perm"
+	"google.golang.org/grpc"
+)
+
+// The permission checks the server records.
+const (
+	HasPermissionMethod        = "HasPermission"
+	ListResourcePatternsMethod = "ListResourcePatterns"
+)
+
+// Call is a permission check the server answered, from any caller. Resource
+// and HasPermission are only set for HasPermission calls, and
+// ResourcePatterns only for ListResourcePatterns calls.
+type Call struct {
+	Method    string
+	StartedAt time.Time
+	Latency   time.Duration
+
+	Actor  perm.Actor
+	Groups []perm.Group
+	Action string
+
+	Resource      string
+	HasPermission bool
+
+	ResourcePatterns []string
+
+	Err error
+}
+
+type callRecorder struct {
+	mu    sync.RWMutex
+	calls []Call
+}
+
+// intercept records HasPermission and ListResourcePatterns calls, including
+// those that fail.
+func (r *callRecorder) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := path.Base(info.FullMethod)
+	if method != HasPermissionMethod && method != ListResourcePatternsMethod {
+		return handler(ctx, req)
+	}
+
+	call := Call{
+		Method:    method,
+		StartedAt: time.Now(),
+	}
+
+	res, err := handler(ctx, req)
+
+	call.Latency = time.Since(call.StartedAt)
+	call.Err = err
+
+	switch req := req.(type) {
+	case *protos.HasPermissionRequest:
+		call.Actor = actor(req.GetActor())
+		call.Groups = groups(req.GetGroups())
+		call.Action = req.GetAction()
+		call.Resource = req.GetResource()
+		if res, ok := res.(*protos.HasPermissionResponse); ok {
+			call.HasPermission = res.GetHasPermission()
+		}
+	case *protos.ListResourcePatternsRequest:
+		call.Actor = actor(req.GetActor())
+		call.Groups = groups(req.GetGroups())
+		call.Action = req.GetAction()
+		if res, ok := res.(*protos.ListResourcePatternsResponse); ok {
+			call.ResourcePatterns = res.GetResourcePatterns()
+		}
+	}
+
+	r.mu.Lock()
+	r.calls = append(r.calls, call)
+	r.mu.Unlock()
+
+	return res, err
+}
+
+func (r *callRecorder) list(methods ...string) []Call {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var calls []Call
+	for _, call := range r.calls {
+		if len(methods) > 0 && !containsString(methods, call.Method) {
+			continue
+		}
+		calls = append(calls, call)
+	}
+
+	return calls
+}
+
+func (r *callRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = nil
+}
+
+func actor(a *protos.Actor) perm.Actor {
+	return perm.Actor{
+		ID:        a.GetID(),
+		Namespace: a.GetNamespace(),
+	}
+}
+
+func groups(gs []*protos.Group) []perm.Group {
+	var groups []perm.Group
+	for _, g := range gs {
+		groups = append(groups, perm.Group{ID: g.GetID()})
+	}
+
+	return groups
+}
+
+// Calls lists the permission checks the server has answered, in the order
+// they finished, optionally limited to the given methods.
+func (s *PermServer) Calls(methods ...string) []Call {
+	return s.calls.list(methods...)
+}
+
+// ResetCalls forgets every call recorded so far.
+func (s *PermServer) ResetCalls() {
+	s.calls.reset()
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
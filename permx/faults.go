@@ -0,0 +1,154 @@
+package permx
+
+import (
+	"context"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AllMethods can be passed in place of a method, e.g. HasPermissionMethod,
+// to apply a fault to every call the server receives.
+const AllMethods = "*"
+
+// Fault describes how the server misbehaves for a method. Latency is applied
+// first, and the call then fails with Code if it is set.
+type Fault struct {
+	// Latency delays the call by a fixed duration, plus a random duration in
+	// [0, LatencyJitter). Callers whose deadline passes first see
+	// DeadlineExceeded.
+	Latency       time.Duration
+	LatencyJitter time.Duration
+
+	// Code fails the call with the gRPC status code, e.g. codes.Unavailable
+	// or codes.DeadlineExceeded, instead of forwarding it to perm.
+	Code codes.Code
+
+	// Times limits the fault to the next n matching calls. Zero means the
+	// fault applies until it is cleared.
+	Times int
+}
+
+type faultInjector struct {
+	mu     sync.Mutex
+	faults map[string]*Fault
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{
+		faults: make(map[string]*Fault),
+	}
+}
+
+func (f *faultInjector) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := path.Base(info.FullMethod)
+
+	fault, ok := f.take(method)
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	latency := fault.Latency
+	if fault.LatencyJitter > 0 {
+		latency += time.Duration(rand.Int63n(int64(fault.LatencyJitter)))
+	}
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+		}
+		return nil, status.Error(codes.Canceled, ctx.Err().Error())
+	}
+
+	if fault.Code != codes.OK {
+		return nil, status.Errorf(fault.Code, "injected fault for %s", method)
+	}
+
+	return handler(ctx, req)
+}
+
+func (f *faultInjector) inject(method string, fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.faults[method] = &fault
+}
+
+func (f *faultInjector) clear(method string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.faults, method)
+}
+
+func (f *faultInjector) clearAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.faults = make(map[string]*Fault)
+}
+
+func (f *faultInjector) list() map[string]Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	faults := make(map[string]Fault, len(f.faults))
+	for method, fault := range f.faults {
+		faults[method] = *fault
+	}
+
+	return faults
+}
+
+func (f *faultInjector) take(method string) (Fault, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, key := range []string{method, AllMethods} {
+		fault, ok := f.faults[key]
+		if !ok {
+			continue
+		}
+
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				delete(f.faults, key)
+			}
+		}
+
+		return *fault, true
+	}
+
+	return Fault{}, false
+}
+
+// InjectFault makes the server misbehave for the method (or AllMethods)
+// until the fault is cleared or has been applied Fault.Times times. Calls
+// that fail are still recorded, see Calls.
+func (s *PermServer) InjectFault(method string, fault Fault) {
+	s.faults.inject(method, fault)
+}
+
+// Faults lists the faults currently injected, keyed by method.
+func (s *PermServer) Faults() map[string]Fault {
+	return s.faults.list()
+}
+
+func (s *PermServer) ClearFault(method string) {
+	s.faults.clear(method)
+}
+
+func (s *PermServer) ClearFaults() {
+	s.faults.clearAll()
+}
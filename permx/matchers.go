@@ -0,0 +1,72 @@
+package permx
+
+import (
+	"github.com/onsi/gomega"
+	"github.com/onsi/gomega/types"
+)
+
+// HaveCheckedPermission succeeds if a []Call has a HasPermission call for
+// the action on the resource pattern, e.g.
+//
+//	Expect(permServer.Calls()).To(HaveCheckedPermission("task.read", "org/space"))
+func HaveCheckedPermission(action, resource string) types.GomegaMatcher {
+	return gomega.ContainElement(gomega.SatisfyAll(
+		HaveCallMethod(HasPermissionMethod),
+		HaveCallAction(action),
+		HaveCallResource(resource),
+	))
+}
+
+// HaveListedResourcePatterns succeeds if a []Call has a ListResourcePatterns
+// call for the action.
+func HaveListedResourcePatterns(action string) types.GomegaMatcher {
+	return gomega.ContainElement(gomega.SatisfyAll(
+		HaveCallMethod(ListResourcePatternsMethod),
+		HaveCallAction(action),
+	))
+}
+
+// The matchers below operate on a Call, e.g.
+//
+//	Expect(permServer.Calls()).To(ContainElement(SatisfyAll(
+//		HaveCallActorID(user.UUID),
+//		HaveCallAction("task.read"),
+//		HaveCallResult(true),
+//	)))
+
+func HaveCallMethod(method string) types.GomegaMatcher {
+	return gomega.WithTransform(func(call Call) string {
+		return call.Method
+	}, gomega.Equal(method))
+}
+
+func HaveCallActorID(id string) types.GomegaMatcher {
+	return gomega.WithTransform(func(call Call) string {
+		return call.Actor.ID
+	}, gomega.Equal(id))
+}
+
+func HaveCallAction(action string) types.GomegaMatcher {
+	return gomega.WithTransform(func(call Call) string {
+		return call.Action
+	}, gomega.Equal(action))
+}
+
+func HaveCallResource(resource string) types.GomegaMatcher {
+	return gomega.WithTransform(func(call Call) string {
+		return call.Resource
+	}, gomega.Equal(resource))
+}
+
+// HaveCallResult matches HasPermission calls perm answered, with the answer.
+func HaveCallResult(hasPermission bool) types.GomegaMatcher {
+	return gomega.WithTransform(func(call Call) bool {
+		return call.Err == nil && call.HasPermission == hasPermission
+	}, gomega.BeTrue())
+}
+
+func HaveCallResourcePatterns(resources ...string) types.GomegaMatcher {
+	return gomega.WithTransform(func(call Call) []string {
+		return call.ResourcePatterns
+	}, gomega.ConsistOf(resources))
+}
@@ -0,0 +1,450 @@
+package permx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/mvcc"
+	"code.cloudfoundry.org/perm/pkg/api"
+	"code.cloudfoundry.org/perm/pkg/api/logging"
+	"code.cloudfoundry.org/perm/pkg/perm"
+	"code.cloudfoundry.org/perm/pkg/sqlx"
+	oidc "github.com/coreos/go-oidc"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const DefaultShutdownTimeout = 5 * time.Second
+
+var ErrInvalidCA = errors.New("invalid CA certificate")
+
+// PermServer runs a perm server in-process on an ephemeral localhost port,
+// with a TLS certificate signed by a CA it generates unless it is given one,
+// and a client connected to it. Roles live in memory unless WithSQL is
+// given, and calls are not authenticated unless WithOIDC is. It records the
+// permission checks it answers, see Calls, and misbehaves on demand, see
+// InjectFault and Stop.
+type PermServer struct {
+	logger        lager.Logger
+	serverOptions []api.ServerOption
+	sqlOptions    *SQLOptions
+	oidcOptions   *OIDCOptions
+	securityLog   io.Writer
+
+	caPEM       []byte
+	certificate *tls.Certificate
+
+	db *sqlx.DB
+
+	// backend is perm itself, on a plaintext localhost listener. front
+	// terminates TLS on listener, intercepts calls and forwards them to it.
+	backend         *api.Server
+	backendListener net.Listener
+	backendErrs     chan error
+	backendConn     *grpc.ClientConn
+
+	front     *grpc.Server
+	listener  net.Listener
+	serveErrs chan error
+
+	calls  *callRecorder
+	faults *faultInjector
+
+	caFile string
+	client *perm.Client
+	conn   *grpc.ClientConn
+}
+
+func NewPermServer(opts ...PermServerOption) *PermServer {
+	o := defaultPermServerOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return &PermServer{
+		logger:        o.logger,
+		serverOptions: o.serverOptions,
+		sqlOptions:    o.sqlOptions,
+		oidcOptions:   o.oidcOptions,
+		securityLog:   o.securityLog,
+		caPEM:         o.caPEM,
+		certificate:   o.certificate,
+		calls:         &callRecorder{},
+		faults:        newFaultInjector(),
+	}
+}
+
+// Start serves in the background, writes the CA to a file for the cloud
+// controller and connects the client. Close undoes all of it.
+func (s *PermServer) Start() error {
+	if s.certificate == nil {
+		caPEM, cert, err := generateCertificates()
+		if err != nil {
+			return err
+		}
+		s.caPEM, s.certificate = caPEM, &cert
+	}
+
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(s.caPEM) {
+		return ErrInvalidCA
+	}
+
+	serverOptions := []api.ServerOption{
+		api.WithLogger(s.logger),
+	}
+
+	var tokenSource oauth2.TokenSource
+	if s.oidcOptions != nil {
+		provider, err := oidc.NewProvider(context.Background(), s.oidcOptions.IssuerURL)
+		if err != nil {
+			return err
+		}
+		serverOptions = append(serverOptions, api.WithOIDCProvider(provider))
+
+		tokenSource = oauth2.ReuseTokenSource(nil, &clientCredentialsTokenSource{
+			tokenURL:     provider.Endpoint().TokenURL,
+			clientID:     s.oidcOptions.ClientID,
+			clientSecret: s.oidcOptions.ClientSecret,
+		})
+	}
+
+	if s.sqlOptions != nil {
+		conn, err := connectSQL(s.logger, *s.sqlOptions)
+		if err != nil {
+			return err
+		}
+		s.db = conn
+		serverOptions = append(serverOptions, api.WithDBConn(conn))
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		s.Close()
+		return err
+	}
+	s.listener = listener
+
+	if s.securityLog != nil {
+		serverOptions = append(serverOptions, api.WithSecurityLogger(
+			logging.NewCEFLogger(s.securityLog, "cloud_foundry", "perm", "mvcc", "localhost", s.Port()),
+		))
+	}
+
+	if err = s.startBackend(append(serverOptions, s.serverOptions...)); err != nil {
+		s.Close()
+		return err
+	}
+
+	s.serveFront()
+
+	if err = s.writeCAFile(); err != nil {
+		s.Close()
+		return err
+	}
+
+	clientTLSConfig := &tls.Config{
+		RootCAs: rootCAs,
+	}
+
+	dialOptions := []perm.DialOption{perm.WithTLSConfig(clientTLSConfig)}
+	grpcOptions := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig))}
+	if tokenSource != nil {
+		dialOptions = append(dialOptions, perm.WithTokenSource(tokenSource))
+		grpcOptions = append(grpcOptions, grpc.WithPerRPCCredentials(&tokenCredentials{tokenSource: tokenSource}))
+	}
+
+	s.client, err = perm.Dial(s.Addr(), dialOptions...)
+	if err != nil {
+		s.Close()
+		return err
+	}
+
+	s.conn, err = grpc.Dial(s.Addr(), grpcOptions...)
+	if err != nil {
+		s.Close()
+		return err
+	}
+
+	return nil
+}
+
+// serveFront serves the front on the listener in the background. Calls pass
+// the call recorder before the fault injector, so failed calls are recorded.
+func (s *PermServer) serveFront() {
+	s.front = grpc.NewServer(
+		grpc.Creds(credentials.NewServerTLSFromCert(s.certificate)),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
+			s.calls.intercept,
+			s.faults.intercept,
+		)),
+	)
+	newProxy(s.backendConn).register(s.front)
+
+	s.serveErrs = make(chan error, 1)
+	go func() {
+		err := s.front.Serve(s.listener)
+		if err == grpc.ErrServerStopped {
+			err = nil
+		}
+		s.serveErrs <- err
+	}()
+}
+
+// Stop stops serving, dropping the calls in flight, as a crashed perm
+// would. Callers' connections fail until Restart; roles are kept.
+func (s *PermServer) Stop() error {
+	if s.front == nil {
+		return nil
+	}
+
+	s.front.Stop()
+	err := <-s.serveErrs
+	s.front = nil
+
+	return err
+}
+
+// Restart stops serving if need be and serves again on the same port.
+// Clients reconnect with gRPC's backoff, so their first calls may still
+// fail.
+func (s *PermServer) Restart() error {
+	if err := s.Stop(); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", s.listener.Addr().String())
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	s.serveFront()
+
+	return nil
+}
+
+// startBackend serves perm on its own listener and connects the front to it.
+func (s *PermServer) startBackend(serverOptions []api.ServerOption) error {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return err
+	}
+	s.backendListener = listener
+
+	s.backend = api.NewServer(serverOptions...)
+
+	s.backendErrs = make(chan error, 1)
+	go func() {
+		err := s.backend.Serve(listener)
+		if err == api.ErrServerStopped {
+			err = nil
+		}
+		s.backendErrs <- err
+	}()
+
+	s.backendConn, err = grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	return err
+}
+
+// The CA file outlives Start, unlike a deferred removal would, since the
+// cloud controller reads it when it first calls perm.
+func (s *PermServer) writeCAFile() error {
+	f, err := ioutil.TempFile("", "perm-ca")
+	if err != nil {
+		return err
+	}
+	s.caFile = f.Name()
+
+	if _, err = f.Write(s.caPEM); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s *PermServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *PermServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Client is connected to the server until Close.
+func (s *PermServer) Client() *perm.Client {
+	return s.client
+}
+
+// Conn is a gRPC connection to the server for the calls Client lacks, such
+// as assigning roles to groups. It is closed by Close.
+func (s *PermServer) Conn() *grpc.ClientConn {
+	return s.conn
+}
+
+func (s *PermServer) CACertPath() string {
+	return s.caFile
+}
+
+// PermOptions point DialMVCC at the server, as the OIDC client if calls are
+// authenticated.
+func (s *PermServer) PermOptions() mvcc.PermOptions {
+	options := mvcc.PermOptions{
+		Port:       s.Port(),
+		CACertPath: s.caFile,
+	}
+	if s.oidcOptions != nil {
+		options.ClientName = s.oidcOptions.ClientID
+		options.ClientSecret = s.oidcOptions.ClientSecret
+	}
+
+	return options
+}
+
+// Close disconnects the clients, stops the server, giving in-flight calls
+// DefaultShutdownTimeout to finish, and removes the CA file. It returns the
+// first error it meets but always does all of it.
+func (s *PermServer) Close() error {
+	var errs []error
+
+	if s.conn != nil {
+		errs = append(errs, s.conn.Close())
+		s.conn = nil
+	}
+	if s.client != nil {
+		errs = append(errs, s.client.Close())
+		s.client = nil
+	}
+
+	if s.front != nil {
+		errs = append(errs, stop(s.front, s.serveErrs))
+		s.front = nil
+	}
+
+	if s.backendConn != nil {
+		errs = append(errs, s.backendConn.Close())
+		s.backendConn = nil
+	}
+	if s.backend != nil {
+		errs = append(errs, stop(s.backend, s.backendErrs))
+		s.backend = nil
+	}
+
+	if s.db != nil {
+		errs = append(errs, s.db.Close())
+		s.db = nil
+	}
+
+	if s.caFile != "" {
+		errs = append(errs, os.Remove(s.caFile))
+		s.caFile = ""
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type stopper interface {
+	GracefulStop()
+	Stop()
+}
+
+// stop stops the server gracefully, or forcefully after
+// DefaultShutdownTimeout, and returns what Serve did.
+func stop(server stopper, serveErrs chan error) error {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(DefaultShutdownTimeout):
+		server.Stop()
+	}
+
+	return <-serveErrs
+}
+
+type PermServerOption func(*permServerOptions)
+
+type permServerOptions struct {
+	logger        lager.Logger
+	serverOptions []api.ServerOption
+	sqlOptions    *SQLOptions
+	oidcOptions   *OIDCOptions
+	securityLog   io.Writer
+	caPEM         []byte
+	certificate   *tls.Certificate
+}
+
+func defaultPermServerOptions() *permServerOptions {
+	return &permServerOptions{
+		logger: lagertest.NewTestLogger("perm"),
+	}
+}
+
+func WithLogger(logger lager.Logger) PermServerOption {
+	return func(o *permServerOptions) {
+		o.logger = logger
+	}
+}
+
+// WithCertificate serves the certificate instead of generating one. caPEM is
+// the CA that signed it.
+func WithCertificate(cert tls.Certificate, caPEM []byte) PermServerOption {
+	return func(o *permServerOptions) {
+		o.certificate = &cert
+		o.caPEM = caPEM
+	}
+}
+
+// WithSQL stores roles in the database, after migrating it, instead of in
+// memory.
+func WithSQL(options SQLOptions) PermServerOption {
+	return func(o *permServerOptions) {
+		o.sqlOptions = &options
+	}
+}
+
+// WithOIDC authenticates calls with tokens from the issuer. See OIDCOptions.
+func WithOIDC(options OIDCOptions) PermServerOption {
+	return func(o *permServerOptions) {
+		o.oidcOptions = &options
+	}
+}
+
+// WithSecurityLog writes perm's security events to w as CEF lines, one per
+// permission check, role change and, with WithOIDC, authentication.
+func WithSecurityLog(w io.Writer) PermServerOption {
+	return func(o *permServerOptions) {
+		o.securityLog = w
+	}
+}
+
+// WithServerOptions passes options through to api.NewServer, after the
+// logger. TLS is terminated in front of the api.Server, so a TLS config
+// passed here would break the server.
+func WithServerOptions(opts ...api.ServerOption) PermServerOption {
+	return func(o *permServerOptions) {
+		o.serverOptions = append(o.serverOptions, opts...)
+	}
+}
@@ -0,0 +1,81 @@
+package permx
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/perm/pkg/api/protos"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// proxy serves perm's services by forwarding every call to the api.Server,
+// so that the front server can intercept calls the api.Server has no hook
+// for. It forwards the caller's metadata, which carries its token.
+type proxy struct {
+	roles       protos.RoleServiceClient
+	permissions protos.PermissionServiceClient
+}
+
+func newProxy(conn *grpc.ClientConn) *proxy {
+	return &proxy{
+		roles:       protos.NewRoleServiceClient(conn),
+		permissions: protos.NewPermissionServiceClient(conn),
+	}
+}
+
+func (p *proxy) register(server *grpc.Server) {
+	protos.RegisterRoleServiceServer(server, p)
+	protos.RegisterPermissionServiceServer(server, p)
+}
+
+func forward(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+func (p *proxy) CreateRole(ctx context.Context, req *protos.CreateRoleRequest) (*protos.CreateRoleResponse, error) {
+	return p.roles.CreateRole(forward(ctx), req)
+}
+
+func (p *proxy) DeleteRole(ctx context.Context, req *protos.DeleteRoleRequest) (*protos.DeleteRoleResponse, error) {
+	return p.roles.DeleteRole(forward(ctx), req)
+}
+
+func (p *proxy) AssignRole(ctx context.Context, req *protos.AssignRoleRequest) (*protos.AssignRoleResponse, error) {
+	return p.roles.AssignRole(forward(ctx), req)
+}
+
+func (p *proxy) AssignRoleToGroup(ctx context.Context, req *protos.AssignRoleToGroupRequest) (*protos.AssignRoleToGroupResponse, error) {
+	return p.roles.AssignRoleToGroup(forward(ctx), req)
+}
+
+func (p *proxy) UnassignRole(ctx context.Context, req *protos.UnassignRoleRequest) (*protos.UnassignRoleResponse, error) {
+	return p.roles.UnassignRole(forward(ctx), req)
+}
+
+func (p *proxy) UnassignRoleFromGroup(ctx context.Context, req *protos.UnassignRoleFromGroupRequest) (*protos.UnassignRoleFromGroupResponse, error) {
+	return p.roles.UnassignRoleFromGroup(forward(ctx), req)
+}
+
+func (p *proxy) HasRole(ctx context.Context, req *protos.HasRoleRequest) (*protos.HasRoleResponse, error) {
+	return p.roles.HasRole(forward(ctx), req)
+}
+
+func (p *proxy) HasRoleForGroup(ctx context.Context, req *protos.HasRoleForGroupRequest) (*protos.HasRoleForGroupResponse, error) {
+	return p.roles.HasRoleForGroup(forward(ctx), req)
+}
+
+func (p *proxy) ListRolePermissions(ctx context.Context, req *protos.ListRolePermissionsRequest) (*protos.ListRolePermissionsResponse, error) {
+	return p.roles.ListRolePermissions(forward(ctx), req)
+}
+
+func (p *proxy) HasPermission(ctx context.Context, req *protos.HasPermissionRequest) (*protos.HasPermissionResponse, error) {
+	return p.permissions.HasPermission(forward(ctx), req)
+}
+
+func (p *proxy) ListResourcePatterns(ctx context.Context, req *protos.ListResourcePatternsRequest) (*protos.ListResourcePatternsResponse, error) {
+	return p.permissions.ListResourcePatterns(forward(ctx), req)
+}
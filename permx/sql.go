@@ -0,0 +1,41 @@
+package permx
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/perm/pkg/api/db"
+	"code.cloudfoundry.org/perm/pkg/sqlx"
+)
+
+// SQLOptions locate the database the server stores roles in. Perm's data
+// service only speaks MySQL.
+type SQLOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Database string
+}
+
+// connectSQL connects and applies perm's migrations, which are idempotent.
+func connectSQL(logger lager.Logger, options SQLOptions) (*sqlx.DB, error) {
+	conn, err := sqlx.Connect(sqlx.DBDriverMySQL,
+		sqlx.DBHost(options.Host),
+		sqlx.DBPort(options.Port),
+		sqlx.DBUsername(options.Username),
+		sqlx.DBPassword(options.Password),
+		sqlx.DBDatabaseName(options.Database),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sqlx.ApplyMigrations(context.Background(), logger.Session("migrations"), conn, db.MigrationsTableName, db.Migrations)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -49,7 +49,9 @@ type v3PackageRequest struct {
 		} `json:"app"`
 	} `json:"relationships"`
 	Data struct {
-		Image string `json:"image"`
+		Image    string `json:"image"`
+		Username string `json:"username,omitempty"`
+		Password string `json:"password,omitempty"`
 	} `json:"data"`
 }
 
@@ -67,3 +69,32 @@ type v3TaskRequest struct {
 type v2FeatureFlagRequest struct {
 	Enabled bool `json:"enabled"`
 }
+
+type v2UserRequest struct {
+	GUID string `json:"guid"`
+}
+
+type v3RoleRequest struct {
+	Type          string `json:"type"`
+	Relationships struct {
+		User struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"user"`
+		Organization *v3RoleRelationship `json:"organization,omitempty"`
+		Space        *v3RoleRelationship `json:"space,omitempty"`
+	} `json:"relationships"`
+}
+
+type v3RoleRelationship struct {
+	Data struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
+
+type v3CurrentDropletRequest struct {
+	Data struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
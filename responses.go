@@ -60,9 +60,22 @@ type v3BuildResponse struct {
 type v3TaskResponse struct {
 	Name        string `json:"name"`
 	GUID        string `json:"guid"`
+	State       string `json:"state"`
 	DropletGUID string `json:"droplet_guid"`
 }
 
 type v3ListTasksResponse struct {
 	Resources []v3TaskResponse `json:"resources"`
 }
+
+type v2EventsResponse struct {
+	Resources []struct {
+		Metadata struct {
+			GUID string `json:"guid"`
+		} `json:"metadata"`
+		Entity struct {
+			Type  string `json:"type"`
+			Actee string `json:"actee"`
+		} `json:"entity"`
+	} `json:"resources"`
+}
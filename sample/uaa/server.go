@@ -1,66 +1,43 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"net"
-	"net/http"
-	"net/http/httptest"
+	"log"
 	"os"
-	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/mvcc/uaax"
+)
+
+var (
+	listenAddress = flag.String("listenAddress", "localhost:6789", "address the fake UAA listens on")
+	issuer        = flag.String("issuer", "", "issuer of the tokens (defaults to the server's URL)")
+	userName      = flag.String("userName", "admin", "name of the user to create")
+	password      = flag.String("password", "admin", "password of the user to create")
 )
 
 func main() {
-	oauthServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		switch req.URL.Path {
-		case "/.well-known/openid-configuration":
-			fmt.Println("req to openid-configuration")
-			w.Write([]byte(fmt.Sprintf(`
-{
-  "issuer": "http://%s",
-  "jwks_uri": "http://%s/token_keys"
-}`, req.Host, req.Host)))
-		case "/token_keys":
-			fmt.Println("req to token_keys")
-			w.Write([]byte(`
-{
-	"keys": [
-		{
-			"kty": "HSA",
-			"e": "AQAB",
-			"n": "APdfDb_pJpRhdbs9jDCWifExV81fROWxVVaLSh8R5dRxEIY8RRVS5AYmoI4etabv3gubYw3cgNIJGFqS3xLwJwGrVf7fh8YIIxm_H6QNG2mys7Rn80RufRpqrkas0EBcJqa_zQpS3QnINJ6ZkrSXhghYdD0R_01VQpQ7OnXFLKCAxUo7y0vUiMQhdKf0y8YhRd5v-cvujgze0vQnWrDQ9UY224OPnNtJK1zv2E7Ssn43PTEt1OxF2lYLuSqJUw8lEiE8FTQIBIUj0yqfiMQ8dn4GeJem8nTfsRyNHBOHF-HddJW-RrQ-ryvFLLpFu0H0wecSzlF-5SXOsTUpGuMQ0pU"
-		}
-	]
-}`))
-		default:
-			fmt.Printf("unhandled request: %#v", req)
-			data, err := ioutil.ReadAll(req.Body)
-			if err != nil {
-				fmt.Println(err.Error())
-				os.Exit(1)
-			}
-			fmt.Printf("body: %s", data)
-			w.Write([]byte("{}"))
-		}
-	}))
+	flag.Parse()
 
-	err := oauthServer.Listener.Close()
-	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
-	}
+	logger := lager.NewLogger("fake-uaa")
+	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.DEBUG))
 
-	customListener, err := net.Listen("tcp", "localhost:6789")
-	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+	opts := []uaax.UAAServerOption{
+		uaax.WithLogger(logger),
+		uaax.WithUsers(uaax.User{
+			UserName: *userName,
+			Password: *password,
+			Email:    *userName,
+			Groups:   append([]string{"cloud_controller.admin"}, uaax.DefaultUserGroups...),
+		}),
+	}
+	if *issuer != "" {
+		opts = append(opts, uaax.WithIssuer(*issuer))
 	}
 
-	oauthServer.Listener = customListener
-	oauthServer.Start()
-
-	fmt.Println("listening on :6789")
+	server := uaax.NewUAAServer(opts...)
 
-	time.Sleep(30 * time.Minute)
+	fmt.Printf("listening on %s\n", *listenAddress)
+	log.Fatal(server.ListenAndServe(*listenAddress))
 }
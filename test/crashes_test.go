@@ -0,0 +1,84 @@
+package test_test
+
+import (
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/mvcc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Crashes", func() {
+	var (
+		org mvcc.Organization
+		app mvcc.App
+
+		processGuid string
+	)
+
+	BeforeEach(func() {
+		var err error
+		processGuid = ""
+
+		org, err = cc.V3CreateOrganization(admin.AccessToken)
+		Expect(err).NotTo(HaveOccurred())
+
+		space, err := cc.V3CreateSpace(admin.AccessToken, org)
+		Expect(err).NotTo(HaveOccurred())
+
+		app, err = cc.V3CreateApp(admin.AccessToken, space)
+		Expect(err).NotTo(HaveOccurred())
+
+		pkg, err := cc.V3CreatePackage(admin.AccessToken, app)
+		Expect(err).NotTo(HaveOccurred())
+
+		build, err := cc.V3CreateBuild(admin.AccessToken, pkg)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() string {
+			build, err = cc.V3GetBuild(admin.AccessToken, build.UUID)
+			Expect(err).NotTo(HaveOccurred())
+			return build.State
+		}, 5*time.Second).Should(Equal("STAGED"))
+
+		Expect(cc.V3SetCurrentDroplet(admin.AccessToken, app, build.DropletUUID)).To(Succeed())
+		Expect(cc.V3StartApp(admin.AccessToken, app)).To(Succeed())
+
+		// Diego process GUIDs are the web process's GUID, which is the app's,
+		// followed by its version.
+		Eventually(func() string {
+			for _, lrp := range bbsServer.DesiredLRPs() {
+				if strings.HasPrefix(lrp.ProcessGuid, app.UUID) {
+					processGuid = lrp.ProcessGuid
+				}
+			}
+			return processGuid
+		}, 5*time.Second).ShouldNot(BeEmpty())
+	})
+
+	AfterEach(func() {
+		err := cc.V2DeleteOrganization(admin.AccessToken, org.UUID)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("records an app.crash event and restarts the instance", func() {
+		crashed := bbsServer.ActualLRPs(processGuid)[0]
+		Expect(crashed.State).To(Equal(models.ActualLRPStateRunning))
+
+		Expect(bbsServer.CrashInstance(processGuid, 0, "out of memory")).To(Succeed())
+
+		Eventually(func() []mvcc.Event {
+			events, err := cc.V2ListAppEvents(admin.AccessToken, app, "app.crash")
+			Expect(err).NotTo(HaveOccurred())
+			return events
+		}, 5*time.Second).Should(HaveLen(1))
+
+		restarted := bbsServer.ActualLRPs(processGuid)[0]
+		Expect(restarted.State).To(Equal(models.ActualLRPStateRunning))
+		Expect(restarted.InstanceGuid).NotTo(Equal(crashed.InstanceGuid))
+		Expect(restarted.CrashCount).To(BeEquivalentTo(1))
+		Expect(restarted.CrashReason).To(Equal("out of memory"))
+	})
+})
@@ -1,15 +1,22 @@
 package test_test
 
 import (
-	"context"
 	"fmt"
 	"time"
 
 	"code.cloudfoundry.org/mvcc"
-	. "code.cloudfoundry.org/mvcc/helpers"
+	"code.cloudfoundry.org/mvcc/diegox"
+	"code.cloudfoundry.org/mvcc/helpers"
+	"code.cloudfoundry.org/mvcc/helpers/matrix"
+	"code.cloudfoundry.org/mvcc/helpers/parity"
+	"code.cloudfoundry.org/mvcc/helpers/seclog"
+	"code.cloudfoundry.org/mvcc/patterns"
+	"code.cloudfoundry.org/mvcc/permx"
+	"code.cloudfoundry.org/mvcc/uaax"
 	"code.cloudfoundry.org/perm/pkg/perm"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
 )
 
 var _ = Describe("Tasks", func() {
@@ -74,188 +81,186 @@ var _ = Describe("Tasks", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
-	Describe("GET /v3/tasks/:guid", func() {
-		It("succeeds when the subject has `task.read` for the parent space", func() {
-			permission := perm.Permission{
-				Action:          "task.read",
-				ResourcePattern: SpaceResourceID(org.UUID, space.UUID),
-			}
-			roleName := mvcc.RandomUUID("space-read-task")
-
-			_, err := permClient.CreateRole(context.Background(), roleName, permission)
-			Expect(err).NotTo(HaveOccurred())
-
-			defer permClient.DeleteRole(context.Background(), roleName)
-
-			err = permClient.AssignRole(context.Background(), roleName, actor)
-			Expect(err).NotTo(HaveOccurred())
+	target := func() matrix.Target {
+		return matrix.Target{Org: org.UUID, Space: space.UUID}
+	}
 
+	matrix.Describe(matrix.Operation{
+		Name:   "GET /v3/tasks/:guid",
+		Action: "task.read",
+		Call: func() (bool, error) {
 			t, err := cc.V3GetTask(user.AccessToken, task.UUID)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(t).To(Equal(task))
-		})
-
-		It("succeeds when the subject has `task.read` for the parent org", func() {
-			permission := perm.Permission{
-				Action:          "task.read",
-				ResourcePattern: OrgResourceID(org.UUID),
+			return t.UUID == task.UUID, err
+		},
+	}, matrixEnv(target))
+
+	Describe("running tasks", func() {
+		taskState := func(t mvcc.Task) func() string {
+			return func() string {
+				t, err := cc.V3GetTask(admin.AccessToken, t.UUID)
+				Expect(err).NotTo(HaveOccurred())
+				return t.State
 			}
-			roleName := mvcc.RandomUUID("org-read-task")
-
-			_, err := permClient.CreateRole(context.Background(), roleName, permission)
-			Expect(err).NotTo(HaveOccurred())
+		}
 
-			defer permClient.DeleteRole(context.Background(), roleName)
+		// Task commands are run on the host, so only these specs turn local
+		// execution on, and only for the commands they create.
+		BeforeEach(func() {
+			bbsServer.SetLocalTaskExecution(diegox.DefaultTaskExecutionTimeout)
+		})
 
-			err = permClient.AssignRole(context.Background(), roleName, actor)
+		It("succeeds when the command exits zero", func() {
+			succeeding, err := cc.V3CreateTaskWithCommand(admin.AccessToken, app, dropletUUID, "exit 0")
 			Expect(err).NotTo(HaveOccurred())
 
-			t, err := cc.V3GetTask(user.AccessToken, task.UUID)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(t).To(Equal(task))
+			Eventually(taskState(succeeding), 10*time.Second).Should(Equal("SUCCEEDED"))
 		})
 
-		It("fails when the subject has `task.read` for a different space", func() {
-			permission := perm.Permission{
-				Action:          "task.read",
-				ResourcePattern: SpaceResourceID(org.UUID, mvcc.RandomUUID("other-space")),
-			}
-			roleName := mvcc.RandomUUID("space-read-task")
-
-			_, err := permClient.CreateRole(context.Background(), roleName, permission)
+		It("fails when the command exits non-zero", func() {
+			failing, err := cc.V3CreateTaskWithCommand(admin.AccessToken, app, dropletUUID, "exit 1")
 			Expect(err).NotTo(HaveOccurred())
 
-			defer permClient.DeleteRole(context.Background(), roleName)
+			Eventually(taskState(failing), 10*time.Second).Should(Equal("FAILED"))
+		})
+	})
+
+	Describe("security events", func() {
+		It("logs the permission check in perm and the request in the cloud controller", func() {
+			grant(actor, helpers.On(patterns.Space(org.UUID, space.UUID).String(), "task.read")...)
 
-			err = permClient.AssignRole(context.Background(), roleName, actor)
+			_, err := cc.V3GetTask(user.AccessToken, task.UUID)
 			Expect(err).NotTo(HaveOccurred())
 
-			_, err = cc.V3GetTask(user.AccessToken, task.UUID)
-			Expect(err).To(MatchError(mvcc.ErrNotFound))
+			Expect(permEvents).To(seclog.HaveLogged(seclog.Event{
+				Product:   seclog.ProductPerm,
+				Signature: "HasPermission",
+				Actor:     user.UUID,
+				Action:    "task.read",
+			}))
+			Eventually(ccEvents).Should(seclog.HaveLoggedRequest(user.UUID, "GET /v3/tasks/"+task.UUID, seclog.Success))
 		})
 
-		It("fails when the subject has `task.read` for a different organization", func() {
-			permission := perm.Permission{
-				Action:          "task.read",
-				ResourcePattern: OrgResourceID(mvcc.RandomUUID("other-org")),
-			}
-			roleName := mvcc.RandomUUID("org-read-task")
+		It("logs the request as a client error when the check fails", func() {
+			_, err := cc.V3GetTask(user.AccessToken, task.UUID)
+			Expect(err).To(MatchError(mvcc.ErrNotFound))
 
-			_, err := permClient.CreateRole(context.Background(), roleName, permission)
-			Expect(err).NotTo(HaveOccurred())
+			Eventually(ccEvents).Should(seclog.HaveLoggedRequest(user.UUID, "GET /v3/tasks/"+task.UUID, seclog.ClientError))
+		})
+	})
 
-			defer permClient.DeleteRole(context.Background(), roleName)
+	Describe("perm calls", func() {
+		It("asks perm whether the user can read tasks in the task's space", func() {
+			resource := patterns.Space(org.UUID, space.UUID).String()
+			grant(actor, helpers.On(resource, "task.read")...)
 
-			err = permClient.AssignRole(context.Background(), roleName, actor)
+			_, err := cc.V3GetTask(user.AccessToken, task.UUID)
 			Expect(err).NotTo(HaveOccurred())
 
-			_, err = cc.V3GetTask(user.AccessToken, task.UUID)
-			Expect(err).To(MatchError(mvcc.ErrNotFound))
+			calls := permServer.Calls(permx.HasPermissionMethod)
+			Expect(calls).To(permx.HaveCheckedPermission("task.read", resource))
+			Expect(calls).To(ContainElement(SatisfyAll(
+				permx.HaveCallActorID(user.UUID),
+				permx.HaveCallAction("task.read"),
+				permx.HaveCallResource(resource),
+				permx.HaveCallResult(true),
+			)))
 		})
 	})
 
-	Describe("GET /v3/tasks", func() {
-		var (
-			anotherTask mvcc.Task
-			err         error
-		)
-
-		Context("when there are multiple tasks belonging to a space", func() {
-			BeforeEach(func() {
-				anotherTask, err = cc.V3CreateTask(admin.AccessToken, app, dropletUUID)
-				Expect(err).NotTo(HaveOccurred())
-			})
-
-			It("returns both tasks when the subject has `task.read` for the parent space", func() {
-				permission := perm.Permission{
-					Action:          "task.read",
-					ResourcePattern: SpaceResourceID(org.UUID, space.UUID),
-				}
-				roleName := mvcc.RandomUUID("space-read-task")
+	Describe("when perm is degraded", func() {
+		getTask := func(u mvcc.User) error {
+			_, err := cc.V3GetTask(u.AccessToken, task.UUID)
+			return err
+		}
 
-				_, err := permClient.CreateRole(context.Background(), roleName, permission)
-				Expect(err).NotTo(HaveOccurred())
+		BeforeEach(func() {
+			grant(actor, helpers.On(patterns.Space(org.UUID, space.UUID).String(), "task.read")...)
+		})
 
-				defer permClient.DeleteRole(context.Background(), roleName)
+		It("fails closed for users but not admins when perm is unavailable", func() {
+			permServer.InjectFault(permx.AllMethods, permx.Fault{Code: codes.Unavailable})
 
-				err = permClient.AssignRole(context.Background(), roleName, actor)
-				Expect(err).NotTo(HaveOccurred())
+			Expect(getTask(user)).To(MatchError(mvcc.ErrNotFound))
+			Expect(getTask(admin)).To(Succeed())
+		})
 
-				tasks, err := cc.V3ListTasks(user.AccessToken)
-				Expect(err).NotTo(HaveOccurred())
-				Expect(tasks).To(HaveLen(2))
-				Expect(tasks).To(ContainElement(task))
-				Expect(tasks).To(ContainElement(anotherTask))
-			})
-
-			It("returns no tasks when the subject has `task.read` for another space", func() {
-				permission := perm.Permission{
-					Action:          "task.read",
-					ResourcePattern: SpaceResourceID(org.UUID, "some-other-space"),
-				}
-				roleName := mvcc.RandomUUID("space-read-task")
+		It("falls back to CCDB roles when perm is unavailable", func() {
+			developerUUID := mvcc.RandomUUID("developer")
+			developer := mvcc.User{
+				UUID:        developerUUID,
+				AccessToken: mintToken(uaax.UserToken(developerUUID, "developer", validIssuer)),
+			}
+			Expect(cc.V2CreateUser(admin.AccessToken, developer.UUID)).To(Succeed())
+			Expect(cc.V2AssociateOrganizationRole(admin.AccessToken, org.UUID, parity.OrgUser.Association, developer.UUID)).To(Succeed())
+			Expect(cc.V2AssociateSpaceRole(admin.AccessToken, space.UUID, parity.SpaceDeveloper.Association, developer.UUID)).To(Succeed())
 
-				_, err := permClient.CreateRole(context.Background(), roleName, permission)
-				Expect(err).NotTo(HaveOccurred())
+			permServer.InjectFault(permx.AllMethods, permx.Fault{Code: codes.Unavailable})
 
-				defer permClient.DeleteRole(context.Background(), roleName)
+			Expect(getTask(developer)).To(Succeed())
+			Expect(getTask(user)).To(MatchError(mvcc.ErrNotFound))
+		})
 
-				err = permClient.AssignRole(context.Background(), roleName, actor)
-				Expect(err).NotTo(HaveOccurred())
+		It("fails closed when perm answers after the cloud controller's timeout", func() {
+			permServer.InjectFault(permx.AllMethods, permx.Fault{Latency: time.Second})
 
-				tasks, err := cc.V3ListTasks(user.AccessToken)
-				Expect(err).NotTo(HaveOccurred())
-				Expect(tasks).To(HaveLen(0))
-			})
+			Expect(getTask(user)).To(MatchError(mvcc.ErrNotFound))
 		})
 
-		Context("when there are multiple tasks belonging to an organization", func() {
-			BeforeEach(func() {
-				anotherTask, err = cc.V3CreateTask(admin.AccessToken, app, dropletUUID)
-				Expect(err).NotTo(HaveOccurred())
-			})
+		It("recovers once perm restarts", func() {
+			Expect(permServer.Stop()).To(Succeed())
+			Expect(getTask(user)).To(MatchError(mvcc.ErrNotFound))
 
-			It("returns both tasks when the subject has `task.read` for the parent org", func() {
-				permission := perm.Permission{
-					Action:          "task.read",
-					ResourcePattern: OrgResourceID(org.UUID),
-				}
-				roleName := mvcc.RandomUUID("org-read-task")
+			Expect(permServer.Restart()).To(Succeed())
+			Eventually(func() error { return getTask(user) }, 5*time.Second).Should(Succeed())
+		})
+	})
 
-				_, err := permClient.CreateRole(context.Background(), roleName, permission)
-				Expect(err).NotTo(HaveOccurred())
+	Context("when there are multiple tasks in the space", func() {
+		var anotherTask mvcc.Task
 
-				defer permClient.DeleteRole(context.Background(), roleName)
+		BeforeEach(func() {
+			var err error
 
-				err = permClient.AssignRole(context.Background(), roleName, actor)
-				Expect(err).NotTo(HaveOccurred())
+			anotherTask, err = cc.V3CreateTask(admin.AccessToken, app, dropletUUID)
+			Expect(err).NotTo(HaveOccurred())
+		})
 
+		matrix.Describe(matrix.Operation{
+			Name:   "GET /v3/tasks",
+			Action: "task.read",
+			List:   true,
+			Call: func() (bool, error) {
 				tasks, err := cc.V3ListTasks(user.AccessToken)
-				Expect(err).NotTo(HaveOccurred())
-				Expect(tasks).To(HaveLen(2))
-				Expect(tasks).To(ContainElement(task))
-				Expect(tasks).To(ContainElement(anotherTask))
-			})
-
-			It("returns no tasks when the subject has `task.read` for another org", func() {
-				permission := perm.Permission{
-					Action:          "task.read",
-					ResourcePattern: OrgResourceID("some-other-org"),
+				if len(tasks) == 0 {
+					return false, err
 				}
-				roleName := mvcc.RandomUUID("org-read-task")
-
-				_, err := permClient.CreateRole(context.Background(), roleName, permission)
-				Expect(err).NotTo(HaveOccurred())
 
-				defer permClient.DeleteRole(context.Background(), roleName)
+				var uuids []string
+				for _, t := range tasks {
+					uuids = append(uuids, t.UUID)
+				}
+				Expect(uuids).To(ConsistOf(task.UUID, anotherTask.UUID))
+				return true, err
+			},
+		}, matrixEnv(target))
+	})
 
-				err = permClient.AssignRole(context.Background(), roleName, actor)
-				Expect(err).NotTo(HaveOccurred())
+	Describe("parity with CCDB roles", func() {
+		It("lets a SpaceDeveloper and an actor with `task.read` for the space see the same tasks", func() {
+			spaceDeveloper := parity.Role{
+				CCDB: parity.SpaceDeveloper,
+				Permissions: func(t parity.Target) []perm.Permission {
+					return helpers.On(patterns.Space(t.Org, t.Space).String(), "task.read")
+				},
+			}
 
-				tasks, err := cc.V3ListTasks(user.AccessToken)
-				Expect(err).NotTo(HaveOccurred())
-				Expect(tasks).To(HaveLen(0))
-			})
+			report, err := parity.Check(parityEnv(), spaceDeveloper,
+				parity.Target{Org: org.UUID, Space: space.UUID},
+				parity.Operation{Name: "GET /v3/tasks/:guid", Method: "GET", Path: "/v3/tasks/" + task.UUID},
+				parity.Operation{Name: "GET /v3/tasks", Method: "GET", Path: "/v3/tasks"},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Differences()).To(BeEmpty(), report.String())
 		})
 	})
 })
@@ -0,0 +1,32 @@
+package uaax
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+
+	ErrUnknownKey     = errors.New("unknown key")
+	ErrLastSigningKey = errors.New("cannot retire the last signing key")
+)
+
+// oauthError is the body UAA answers failed OAuth requests with.
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+type ErrInvalidFilter struct {
+	Filter string
+	Reason string
+}
+
+func (e *ErrInvalidFilter) Error() string {
+	if e.Filter == "" {
+		return fmt.Sprintf("invalid filter: %s", e.Reason)
+	}
+	return fmt.Sprintf("invalid filter %q: %s", e.Filter, e.Reason)
+}
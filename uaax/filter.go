@@ -0,0 +1,221 @@
+package uaax
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// scimFilter matches users against a SCIM filter such as
+//
+//	userName eq "bob" and (origin eq "uaa" or origin eq "ldap")
+//
+// It supports the eq, co, sw and pr operators, and and or, and parentheses,
+// which is all the cloud controller sends.
+type scimFilter func(User) bool
+
+func parseSCIMFilter(filter string) (scimFilter, error) {
+	if strings.TrimSpace(filter) == "" {
+		return func(User) bool { return true }, nil
+	}
+
+	tokens, err := tokenizeSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if invalid, ok := err.(*ErrInvalidFilter); ok {
+		invalid.Filter = filter
+		return nil, invalid
+	} else if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, &ErrInvalidFilter{Filter: filter, Reason: fmt.Sprintf("unexpected %q", p.tokens[p.pos].text)}
+	}
+
+	return f, nil
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeSCIMFilter(filter string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	runes := []rune(filter)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, filterToken{text: string(r)})
+			i++
+		case r == '"':
+			var value []rune
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value = append(value, runes[i])
+			}
+			if i == len(runes) {
+				return nil, &ErrInvalidFilter{Filter: filter, Reason: "unterminated string"}
+			}
+			tokens = append(tokens, filterToken{text: string(value), quoted: true})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' {
+				i++
+			}
+			tokens = append(tokens, filterToken{text: string(runes[start:i])})
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, keyword)
+}
+
+func (p *filterParser) next() (filterToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, false
+	}
+	p.pos++
+	return p.tokens[p.pos-1], true
+}
+
+func (p *filterParser) parseOr() (scimFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(u User) bool { return l(u) || r(u) }
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (scimFilter, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(u User) bool { return l(u) && r(u) }
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseTerm() (scimFilter, error) {
+	if p.peekKeyword("(") {
+		p.pos++
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekKeyword(")") {
+			return nil, &ErrInvalidFilter{Reason: "missing )"}
+		}
+		p.pos++
+		return f, nil
+	}
+
+	attr, ok := p.next()
+	if !ok || attr.quoted {
+		return nil, &ErrInvalidFilter{Reason: "expected an attribute"}
+	}
+	op, ok := p.next()
+	if !ok || op.quoted {
+		return nil, &ErrInvalidFilter{Reason: "expected an operator after " + attr.text}
+	}
+
+	if strings.EqualFold(op.text, "pr") {
+		return func(u User) bool {
+			for _, v := range userAttribute(u, attr.text) {
+				if v != "" {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	value, ok := p.next()
+	if !ok || !value.quoted {
+		return nil, &ErrInvalidFilter{Reason: "expected a quoted value after " + attr.text + " " + op.text}
+	}
+
+	var match func(string) bool
+	switch strings.ToLower(op.text) {
+	case "eq":
+		match = func(v string) bool { return strings.EqualFold(v, value.text) }
+	case "co":
+		match = func(v string) bool { return strings.Contains(strings.ToLower(v), strings.ToLower(value.text)) }
+	case "sw":
+		match = func(v string) bool { return strings.HasPrefix(strings.ToLower(v), strings.ToLower(value.text)) }
+	default:
+		return nil, &ErrInvalidFilter{Reason: "unsupported operator " + op.text}
+	}
+
+	return func(u User) bool {
+		for _, v := range userAttribute(u, attr.text) {
+			if match(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// userAttribute returns the values of a SCIM attribute of the user.
+// Attribute names are case insensitive.
+func userAttribute(u User, attr string) []string {
+	switch strings.ToLower(attr) {
+	case "id":
+		return []string{u.ID}
+	case "username":
+		return []string{u.UserName}
+	case "origin":
+		return []string{u.Origin}
+	case "email", "emails", "emails.value":
+		return []string{u.Email}
+	case "name.givenname":
+		return []string{u.GivenName}
+	case "name.familyname":
+		return []string{u.FamilyName}
+	case "groups", "groups.display":
+		return u.Groups
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package uaax
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SCIM filters", func() {
+	bob := User{
+		ID:         "bob-id",
+		UserName:   "bob",
+		Origin:     "uaa",
+		Email:      "bob@example.com",
+		GivenName:  "Bob",
+		FamilyName: "Builder",
+		Groups:     []string{"builders", "admins"},
+	}
+	alice := User{
+		ID:       "alice-id",
+		UserName: "alice",
+		Origin:   "ldap",
+	}
+
+	filters := []struct {
+		filter string
+		bob    bool
+		alice  bool
+	}{
+		{``, true, true},
+		{`   `, true, true},
+
+		{`userName eq "bob"`, true, false},
+		{`username EQ "BOB"`, true, false},
+		{`id eq "alice-id"`, false, true},
+		{`origin eq "uaa"`, true, false},
+		{`emails.value eq "bob@example.com"`, true, false},
+		{`name.givenName eq "bob"`, true, false},
+		{`groups eq "admins"`, true, false},
+		{`unknown eq "bob"`, false, false},
+
+		{`userName co "LIC"`, false, true},
+		{`email co "example"`, true, false},
+		{`userName sw "b"`, true, false},
+		{`userName sw "ob"`, false, false},
+
+		{`email pr`, true, false},
+		{`name.familyName PR`, true, false},
+		{`groups pr`, true, false},
+
+		{`userName eq "bob" and origin eq "uaa"`, true, false},
+		{`userName eq "bob" and origin eq "ldap"`, false, false},
+		{`userName eq "bob" or userName eq "alice"`, true, true},
+		{`userName eq "bob" OR origin eq "ldap"`, true, true},
+
+		{`origin eq "ldap" or userName eq "bob" and origin eq "ldap"`, false, true},
+		{`(origin eq "ldap" or userName eq "bob") and origin eq "ldap"`, false, true},
+		{`userName eq "bob" and (origin eq "uaa" or origin eq "ldap")`, true, false},
+		{`((userName sw "a"))`, false, true},
+
+		{`userName eq "a \"quoted\" name"`, false, false},
+		{`userName eq "or"`, false, false},
+	}
+
+	for _, c := range filters {
+		c := c
+
+		It(fmt.Sprintf("matches %q", c.filter), func() {
+			f, err := parseSCIMFilter(c.filter)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f(bob)).To(Equal(c.bob), "bob")
+			Expect(f(alice)).To(Equal(c.alice), "alice")
+		})
+	}
+
+	invalid := []struct {
+		filter string
+		reason string
+	}{
+		{`userName`, "expected an operator after userName"},
+		{`userName eq`, "expected a quoted value after userName eq"},
+		{`userName eq bob`, "expected a quoted value after userName eq"},
+		{`userName gt "bob"`, "unsupported operator gt"},
+		{`userName eq "bob`, "unterminated string"},
+		{`"userName" eq "bob"`, "expected an attribute"},
+		{`userName eq "bob" and`, "expected an attribute"},
+		{`(userName eq "bob"`, "missing )"},
+		{`userName eq "bob")`, `unexpected ")"`},
+		{`userName eq "bob" userName eq "alice"`, `unexpected "userName"`},
+	}
+
+	for _, c := range invalid {
+		c := c
+
+		It(fmt.Sprintf("rejects %q", c.filter), func() {
+			_, err := parseSCIMFilter(c.filter)
+			Expect(err).To(Equal(&ErrInvalidFilter{Filter: c.filter, Reason: c.reason}))
+		})
+	}
+})
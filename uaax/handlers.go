@@ -0,0 +1,318 @@
+package uaax
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+	JTI          string `json:"jti"`
+}
+
+type userInfoResponse struct {
+	UserID        string `json:"user_id"`
+	Sub           string `json:"sub"`
+	UserName      string `json:"user_name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+func (s *UAAServer) openIDConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	issuer := s.Issuer()
+
+	writeJSON(s.logger, w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                s.URL() + "/oauth/authorize",
+		"token_endpoint":                        s.URL() + TokenEndpoint,
+		"userinfo_endpoint":                     s.URL() + UserInfoEndpoint,
+		"jwks_uri":                              s.URL() + TokenKeysEndpoint,
+		"response_types_supported":              []string{"code", "code id_token", "id_token", "token id_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{s.SigningKey().Algorithm},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"grant_types_supported":                 []string{PasswordGrant, ClientCredentialsGrant, RefreshTokenGrant},
+		"claims_supported":                      []string{"sub", "user_name", "origin", "iss", "auth_time", "amr", "acr", "client_id", "aud", "zid", "grant_type", "user_id", "azp", "scope", "exp", "iat", "jti", "rev_sig", "cid", "given_name", "family_name", "phone_number", "email"},
+	})
+}
+
+// tokenKeysHandler serves the public RSA keys. The symmetric key is never
+// served: it is shared out of band, through the cloud controller's config.
+func (s *UAAServer) tokenKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys := []interface{}{}
+	for _, key := range s.keys.list() {
+		jwk, err := key.jwk()
+		if err != nil {
+			s.logger.Error("failed to encode key", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		keys = append(keys, jwk)
+	}
+
+	writeJSON(s.logger, w, http.StatusOK, map[string]interface{}{
+		"keys": keys,
+	})
+}
+
+// tokenKeyHandler serves the key tokens are currently signed with.
+func (s *UAAServer) tokenKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keys.active()
+	if !ok {
+		writeOAuthError(s.logger, w, http.StatusNotFound, "not_found", "No asymmetric signing key")
+		return
+	}
+
+	jwk, err := key.jwk()
+	if err != nil {
+		s.logger.Error("failed to encode key", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(s.logger, w, http.StatusOK, jwk)
+}
+
+func (s *UAAServer) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(s.logger, w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := s.authenticateClient(r)
+	if !ok {
+		writeOAuthError(s.logger, w, http.StatusUnauthorized, "unauthorized", "Bad credentials")
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	if !client.allowsGrant(grantType) {
+		writeOAuthError(s.logger, w, http.StatusUnauthorized, "invalid_client", "Unauthorized grant type: "+grantType)
+		return
+	}
+
+	now := time.Now()
+	requested := strings.Fields(r.PostForm.Get("scope"))
+
+	var token Token
+	var refreshToken string
+	switch grantType {
+	case PasswordGrant:
+		user, ok := s.store.userByName(r.PostForm.Get("username"), "")
+		if !ok || user.Password != r.PostForm.Get("password") {
+			writeOAuthError(s.logger, w, http.StatusUnauthorized, "unauthorized", "Bad credentials")
+			return
+		}
+
+		scopes, ok := narrowScopes(intersectScopes(user.Groups, client.Scopes), requested)
+		if !ok {
+			writeOAuthError(s.logger, w, http.StatusBadRequest, "invalid_scope", "Invalid scope: "+strings.Join(requested, " "))
+			return
+		}
+
+		token = s.userToken(user, client.ID, grantType, scopes, now)
+		refreshToken = s.store.addRefreshToken(refreshGrant{
+			userID:    user.ID,
+			clientID:  client.ID,
+			scopes:    scopes,
+			expiresAt: now.Add(s.refreshTokenValidity),
+		})
+
+	case ClientCredentialsGrant:
+		scopes, ok := narrowScopes(client.Authorities, requested)
+		if !ok {
+			writeOAuthError(s.logger, w, http.StatusBadRequest, "invalid_scope", "Invalid scope: "+strings.Join(requested, " "))
+			return
+		}
+
+		token = s.clientToken(client.ID, scopes, now)
+
+	case RefreshTokenGrant:
+		refreshToken = r.PostForm.Get("refresh_token")
+		grant, ok := s.store.refreshToken(refreshToken)
+		if !ok || grant.clientID != client.ID || grant.expiresAt.Before(now) {
+			writeOAuthError(s.logger, w, http.StatusUnauthorized, "invalid_token", "Invalid refresh token: "+refreshToken)
+			return
+		}
+
+		user, ok := s.store.user(grant.userID)
+		if !ok {
+			writeOAuthError(s.logger, w, http.StatusUnauthorized, "invalid_token", "Invalid refresh token: "+refreshToken)
+			return
+		}
+
+		scopes, ok := narrowScopes(grant.scopes, requested)
+		if !ok {
+			writeOAuthError(s.logger, w, http.StatusBadRequest, "invalid_scope", "Invalid scope: "+strings.Join(requested, " "))
+			return
+		}
+
+		token = s.userToken(user, client.ID, grantType, scopes, now)
+
+	default:
+		writeOAuthError(s.logger, w, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant type: "+grantType)
+		return
+	}
+
+	claims := token.Claims()
+	accessToken, err := signClaims(claims, s.SigningKey())
+	if err != nil {
+		s.logger.Error("failed to sign token", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(s.logger, w, http.StatusOK, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "bearer",
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.accessTokenValidity / time.Second),
+		Scope:        strings.Join(token.Scopes, " "),
+		JTI:          claims["jti"].(string),
+	})
+}
+
+func (s *UAAServer) userInfoHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.verify(r.Header.Get("Authorization"))
+	if err != nil {
+		writeOAuthError(s.logger, w, http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	}
+
+	if !hasScope(claims, "openid") {
+		writeOAuthError(s.logger, w, http.StatusForbidden, "insufficient_scope", "Insufficient scope for this resource")
+		return
+	}
+
+	userID, _ := claims["user_id"].(string)
+	user, ok := s.store.user(userID)
+	if !ok {
+		writeOAuthError(s.logger, w, http.StatusUnauthorized, "invalid_token", "User not found")
+		return
+	}
+
+	writeJSON(s.logger, w, http.StatusOK, userInfoResponse{
+		UserID:        user.ID,
+		Sub:           user.ID,
+		UserName:      user.UserName,
+		GivenName:     user.GivenName,
+		FamilyName:    user.FamilyName,
+		Name:          strings.TrimSpace(user.GivenName + " " + user.FamilyName),
+		Email:         user.Email,
+		EmailVerified: true,
+	})
+}
+
+func (s *UAAServer) checkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(s.logger, w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if _, ok := s.authenticateClient(r); !ok {
+		writeOAuthError(s.logger, w, http.StatusUnauthorized, "unauthorized", "Bad credentials")
+		return
+	}
+
+	claims, err := s.verify(r.PostForm.Get("token"))
+	if err != nil {
+		writeOAuthError(s.logger, w, http.StatusBadRequest, "invalid_token", err.Error())
+		return
+	}
+
+	writeJSON(s.logger, w, http.StatusOK, claims)
+}
+
+func (s *UAAServer) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	s.logger.Info("unexpected request", lager.Data{"method": r.Method, "path": r.URL.Path})
+	writeOAuthError(s.logger, w, http.StatusNotFound, "not_found", "No handler for "+r.URL.Path)
+}
+
+// authenticateClient accepts client credentials in a basic auth header or
+// in the form, like UAA.
+func (s *UAAServer) authenticateClient(r *http.Request) (Client, bool) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, ok := s.store.client(clientID)
+	if !ok || client.Secret != secret {
+		return Client{}, false
+	}
+
+	return client, true
+}
+
+func intersectScopes(a, b []string) []string {
+	scopes := []string{}
+	for _, scope := range a {
+		if containsString(b, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
+
+// narrowScopes limits the scopes to the requested ones. Asking for a scope
+// that is not allowed fails the request.
+func narrowScopes(allowed, requested []string) ([]string, bool) {
+	if len(requested) == 0 {
+		return append([]string{}, allowed...), true
+	}
+
+	for _, scope := range requested {
+		if !containsString(allowed, scope) {
+			return nil, false
+		}
+	}
+
+	return requested, true
+}
+
+func hasScope(claims map[string]interface{}, scope string) bool {
+	scopes, _ := claims["scope"].([]interface{})
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(logger lager.Logger, w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Error("failed to encode response", err)
+	}
+}
+
+func writeOAuthError(logger lager.Logger, w http.ResponseWriter, statusCode int, code, description string) {
+	writeJSON(logger, w, statusCode, oauthError{
+		Error:       code,
+		Description: description,
+	})
+}
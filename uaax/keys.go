@@ -0,0 +1,167 @@
+package uaax
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sync"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const DefaultRSAKeyBits = 2048
+
+type rsaKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+// keyRing holds the RSA keys the server verifies tokens with. The newest key
+// signs; older keys stay valid until they are retired, as they do while a
+// real UAA rotates keys.
+type keyRing struct {
+	mu   sync.RWMutex
+	keys []rsaKey
+	next int
+}
+
+func (k *keyRing) add(id string, key *rsa.PrivateKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys = append(k.keys, rsaKey{id: id, key: key})
+}
+
+func (k *keyRing) generate(bits int) (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return "", err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var id string
+	for {
+		k.next++
+		id = fmt.Sprintf("key-%d", k.next)
+		if !k.has(id) {
+			break
+		}
+	}
+	k.keys = append(k.keys, rsaKey{id: id, key: key})
+
+	return id, nil
+}
+
+// retire refuses to drop the last key, which the server still signs with.
+func (k *keyRing) retire(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for i, key := range k.keys {
+		if key.id != id {
+			continue
+		}
+		if len(k.keys) == 1 {
+			return ErrLastSigningKey
+		}
+		k.keys = append(k.keys[:i], k.keys[i+1:]...)
+		return nil
+	}
+
+	return ErrUnknownKey
+}
+
+// has must be called with the lock held.
+func (k *keyRing) has(id string) bool {
+	for _, key := range k.keys {
+		if key.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *keyRing) active() (rsaKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.keys) == 0 {
+		return rsaKey{}, false
+	}
+
+	return k.keys[len(k.keys)-1], true
+}
+
+func (k *keyRing) find(id string) (rsaKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.id == id {
+			return key, true
+		}
+	}
+
+	return rsaKey{}, false
+}
+
+func (k *keyRing) list() []rsaKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return append([]rsaKey(nil), k.keys...)
+}
+
+// jwk is the public half of the key as UAA serves it: a standard JWK plus
+// the PEM encoded key in "value", which is what the cloud controller reads.
+func (k rsaKey) jwk() (map[string]interface{}, error) {
+	bits, err := json.Marshal(jose.JSONWebKey{
+		Key:       &k.key.PublicKey,
+		KeyID:     k.id,
+		Algorithm: RS256,
+		Use:       "sig",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var jwk map[string]interface{}
+	if err = json.Unmarshal(bits, &jwk); err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&k.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk["value"] = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	return jwk, nil
+}
+
+// RotateKey generates a new RSA key and signs with it from now on. Tokens
+// signed with earlier keys stay valid until the keys are retired. It returns
+// the new key's ID.
+func (s *UAAServer) RotateKey() (string, error) {
+	return s.keys.generate(DefaultRSAKeyBits)
+}
+
+// RetireKey stops serving and accepting the key.
+func (s *UAAServer) RetireKey(keyID string) error {
+	return s.keys.retire(keyID)
+}
+
+// KeyIDs lists the IDs of the RSA keys the server serves, oldest first.
+func (s *UAAServer) KeyIDs() []string {
+	var ids []string
+	for _, key := range s.keys.list() {
+		ids = append(ids, key.id)
+	}
+
+	return ids
+}
@@ -0,0 +1,193 @@
+package uaax
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+var _ = Describe("Keys", func() {
+	var s *UAAServer
+
+	BeforeEach(func() {
+		s = NewUAAServer()
+	})
+
+	tokenKeys := func() []map[string]string {
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, httptest.NewRequest("GET", TokenKeysEndpoint, nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		var body struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+
+		return body.Keys
+	}
+
+	keyIDs := func(keys []map[string]string) []string {
+		var ids []string
+		for _, key := range keys {
+			ids = append(ids, key["kid"])
+		}
+		return ids
+	}
+
+	It("serves no keys until it has RSA keys", func() {
+		Expect(tokenKeys()).To(BeEmpty())
+		Expect(s.SigningKey().Algorithm).To(Equal(HS256))
+	})
+
+	Describe("RotateKey", func() {
+		It("signs with the new key", func() {
+			id, err := s.RotateKey()
+			Expect(err).NotTo(HaveOccurred())
+
+			token, err := s.MintToken(UserToken("user-id", "bob", ""))
+			Expect(err).NotTo(HaveOccurred())
+
+			signed, err := jose.ParseSigned(token)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(signed.Signatures[0].Header.Algorithm).To(Equal(RS256))
+			Expect(signed.Signatures[0].Header.KeyID).To(Equal(id))
+		})
+
+		It("keeps verifying tokens signed with older keys", func() {
+			first, err := s.RotateKey()
+			Expect(err).NotTo(HaveOccurred())
+			token, err := s.MintToken(UserToken("user-id", "bob", ""))
+			Expect(err).NotTo(HaveOccurred())
+
+			second, err := s.RotateKey()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(second).NotTo(Equal(first))
+			Expect(s.SigningKey().KeyID).To(Equal(second))
+
+			_, err = s.verify(token)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("serves every key, oldest first", func() {
+			first, err := s.RotateKey()
+			Expect(err).NotTo(HaveOccurred())
+			second, err := s.RotateKey()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(s.KeyIDs()).To(Equal([]string{first, second}))
+			Expect(keyIDs(tokenKeys())).To(Equal([]string{first, second}))
+		})
+
+		It("skips IDs already taken", func() {
+			s = NewUAAServer(WithRSAKey("key-1", mustGenerateKey()))
+
+			id, err := s.RotateKey()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).To(Equal("key-2"))
+		})
+	})
+
+	Describe("RetireKey", func() {
+		var first, second string
+
+		BeforeEach(func() {
+			var err error
+			first, err = s.RotateKey()
+			Expect(err).NotTo(HaveOccurred())
+			second, err = s.RotateKey()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("stops serving the key", func() {
+			Expect(s.RetireKey(first)).To(Succeed())
+
+			Expect(s.KeyIDs()).To(Equal([]string{second}))
+			Expect(keyIDs(tokenKeys())).To(Equal([]string{second}))
+		})
+
+		It("stops accepting tokens signed with it", func() {
+			token, err := UserToken("user-id", "bob", "").Sign(s.SigningKey())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(s.RetireKey(second)).To(Succeed())
+
+			_, err = s.verify(token)
+			Expect(err).To(Equal(ErrUnknownKey))
+		})
+
+		It("refuses to retire the last key", func() {
+			Expect(s.RetireKey(first)).To(Succeed())
+			Expect(s.RetireKey(second)).To(Equal(ErrLastSigningKey))
+			Expect(s.KeyIDs()).To(Equal([]string{second}))
+		})
+
+		It("refuses to retire unknown keys", func() {
+			Expect(s.RetireKey("key-0")).To(Equal(ErrUnknownKey))
+		})
+	})
+
+	Describe("JWKS", func() {
+		var key *rsa.PrivateKey
+
+		BeforeEach(func() {
+			key = mustGenerateKey()
+			s = NewUAAServer(WithRSAKey("key-a", key))
+		})
+
+		It("serves the public key as a valid RSA JWK", func() {
+			keys := tokenKeys()
+			Expect(keys).To(HaveLen(1))
+
+			jwk := keys[0]
+			Expect(jwk).To(HaveKeyWithValue("kty", "RSA"))
+			Expect(jwk).To(HaveKeyWithValue("kid", "key-a"))
+			Expect(jwk).To(HaveKeyWithValue("alg", RS256))
+			Expect(jwk).To(HaveKeyWithValue("use", "sig"))
+			Expect(jwk).NotTo(HaveKey("d"))
+
+			n, err := base64.RawURLEncoding.DecodeString(jwk["n"])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(new(big.Int).SetBytes(n)).To(Equal(key.N))
+
+			e, err := base64.RawURLEncoding.DecodeString(jwk["e"])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(int(new(big.Int).SetBytes(e).Int64())).To(Equal(key.E))
+		})
+
+		It("serves the same key as PEM in value", func() {
+			block, _ := pem.Decode([]byte(tokenKeys()[0]["value"]))
+			Expect(block).NotTo(BeNil())
+			Expect(block.Type).To(Equal("PUBLIC KEY"))
+
+			public, err := x509.ParsePKIXPublicKey(block.Bytes)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(public).To(Equal(&key.PublicKey))
+		})
+
+		It("serves the signing key at /token_key", func() {
+			rec := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rec, httptest.NewRequest("GET", TokenKeyEndpoint, nil))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			var jwk map[string]string
+			Expect(json.Unmarshal(rec.Body.Bytes(), &jwk)).To(Succeed())
+			Expect(jwk).To(Equal(tokenKeys()[0]))
+		})
+	})
+})
+
+func mustGenerateKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, DefaultRSAKeyBits)
+	Expect(err).NotTo(HaveOccurred())
+	return key
+}
@@ -0,0 +1,218 @@
+package uaax
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	UsersEndpoint   = "/Users"
+	UserIDsEndpoint = "/ids/Users"
+
+	// UsernameLookupClientID and UsernameLookupClientSecret are the client
+	// the cloud controller resolves user names with. The fake UAA has it by
+	// default and the generated cloud controller config uses it.
+	UsernameLookupClientID     = "cloud_controller_username_lookup"
+	UsernameLookupClientSecret = "secret"
+
+	defaultSCIMCount = 100
+)
+
+var scimSchemas = []string{"urn:scim:schemas:core:1.0"}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary"`
+}
+
+type scimGroup struct {
+	Value   string `json:"value"`
+	Display string `json:"display"`
+	Type    string `json:"type"`
+}
+
+type scimUser struct {
+	ID       string `json:"id"`
+	UserName string `json:"userName"`
+	Name     struct {
+		GivenName  string `json:"givenName"`
+		FamilyName string `json:"familyName"`
+	} `json:"name"`
+	Emails   []scimEmail `json:"emails"`
+	Groups   []scimGroup `json:"groups"`
+	Active   bool        `json:"active"`
+	Verified bool        `json:"verified"`
+	Origin   string      `json:"origin"`
+	ZoneID   string      `json:"zoneId"`
+	Schemas  []string    `json:"schemas"`
+}
+
+type scimUserID struct {
+	ID       string `json:"id"`
+	UserName string `json:"userName"`
+	Origin   string `json:"origin"`
+}
+
+type scimListResponse struct {
+	Resources    []interface{} `json:"resources"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	TotalResults int           `json:"totalResults"`
+	Schemas      []string      `json:"schemas"`
+}
+
+func (s *UAAServer) toSCIMUser(u User) scimUser {
+	user := scimUser{
+		ID:       u.ID,
+		UserName: u.UserName,
+		Active:   true,
+		Verified: true,
+		Origin:   u.Origin,
+		ZoneID:   s.zone,
+		Schemas:  scimSchemas,
+		Emails:   []scimEmail{},
+		Groups:   []scimGroup{},
+	}
+	user.Name.GivenName = u.GivenName
+	user.Name.FamilyName = u.FamilyName
+
+	if u.Email != "" {
+		user.Emails = append(user.Emails, scimEmail{Value: u.Email, Primary: true})
+	}
+	for _, group := range u.Groups {
+		user.Groups = append(user.Groups, scimGroup{Value: group, Display: group, Type: "DIRECT"})
+	}
+
+	return user
+}
+
+// usersHandler serves GET /Users to clients with scim.read.
+func (s *UAAServer) usersHandler(w http.ResponseWriter, r *http.Request) {
+	s.listUsers(w, r, "scim.read", func(u User) interface{} {
+		return s.toSCIMUser(u)
+	})
+}
+
+// userIDsHandler serves GET /ids/Users, which only reveals IDs, user names
+// and origins, to clients with scim.userids.
+func (s *UAAServer) userIDsHandler(w http.ResponseWriter, r *http.Request) {
+	s.listUsers(w, r, "scim.userids", func(u User) interface{} {
+		return scimUserID{ID: u.ID, UserName: u.UserName, Origin: u.Origin}
+	})
+}
+
+func (s *UAAServer) listUsers(w http.ResponseWriter, r *http.Request, scope string, render func(User) interface{}) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := s.verify(r.Header.Get("Authorization"))
+	if err != nil {
+		writeOAuthError(s.logger, w, http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	}
+	if !hasScope(claims, scope) {
+		writeOAuthError(s.logger, w, http.StatusForbidden, "insufficient_scope", "Insufficient scope for this resource")
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := parseSCIMFilter(query.Get("filter"))
+	if err != nil {
+		writeOAuthError(s.logger, w, http.StatusBadRequest, "invalid_filter", err.Error())
+		return
+	}
+
+	startIndex := queryInt(query.Get("startIndex"), 1)
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count := queryInt(query.Get("count"), defaultSCIMCount)
+
+	var users []User
+	for _, user := range s.store.listUsers() {
+		if filter(user) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].UserName != users[j].UserName {
+			return users[i].UserName < users[j].UserName
+		}
+		return users[i].ID < users[j].ID
+	})
+
+	res := scimListResponse{
+		Resources:    []interface{}{},
+		StartIndex:   startIndex,
+		TotalResults: len(users),
+		Schemas:      scimSchemas,
+	}
+
+	attributes := splitAttributes(query.Get("attributes"))
+	for i := startIndex - 1; i < len(users) && len(res.Resources) < count; i++ {
+		resource, err := selectAttributes(render(users[i]), attributes)
+		if err != nil {
+			s.logger.Error("failed to select attributes", err, lager.Data{"attributes": attributes})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.Resources = append(res.Resources, resource)
+	}
+	res.ItemsPerPage = len(res.Resources)
+
+	writeJSON(s.logger, w, http.StatusOK, res)
+}
+
+func queryInt(value string, defaultValue int) int {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return i
+}
+
+func splitAttributes(value string) []string {
+	var attributes []string
+	for _, attr := range strings.Split(value, ",") {
+		if attr = strings.TrimSpace(attr); attr != "" {
+			attributes = append(attributes, attr)
+		}
+	}
+	return attributes
+}
+
+// selectAttributes trims the resource to the requested top-level attributes,
+// matched case insensitively. No attributes means all of them.
+func selectAttributes(resource interface{}, attributes []string) (interface{}, error) {
+	if len(attributes) == 0 {
+		return resource, nil
+	}
+
+	bits, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var all map[string]interface{}
+	if err = json.Unmarshal(bits, &all); err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]interface{}, len(attributes))
+	for name, value := range all {
+		for _, attr := range attributes {
+			if strings.EqualFold(name, attr) {
+				selected[name] = value
+			}
+		}
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,227 @@
+package uaax
+
+import (
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultUserGroups are the groups UAA adds every new user to. Users created
+// without groups get these.
+var DefaultUserGroups = []string{
+	"openid",
+	"cloud_controller.read",
+	"cloud_controller.write",
+	"uaa.user",
+}
+
+type User struct {
+	ID         string   `json:"id"`
+	UserName   string   `json:"user_name"`
+	Password   string   `json:"password"`
+	Origin     string   `json:"origin"`
+	Email      string   `json:"email"`
+	GivenName  string   `json:"given_name"`
+	FamilyName string   `json:"family_name"`
+	Groups     []string `json:"groups"`
+}
+
+// Client is an OAuth client. Scopes bound the scopes of tokens issued to
+// users through the client; Authorities are the scopes of its own
+// client_credentials tokens.
+type Client struct {
+	ID          string   `json:"client_id"`
+	Secret      string   `json:"client_secret"`
+	GrantTypes  []string `json:"authorized_grant_types"`
+	Scopes      []string `json:"scope"`
+	Authorities []string `json:"authorities"`
+}
+
+// DefaultClients are the cf CLI's client, which uses the password grant
+// without a secret, and the cloud controller's user name lookup client.
+func DefaultClients() []Client {
+	return []Client{
+		{
+			ID:         "cf",
+			GrantTypes: []string{PasswordGrant, RefreshTokenGrant},
+			Scopes: []string{
+				"openid",
+				"cloud_controller.read",
+				"cloud_controller.write",
+				"cloud_controller.admin",
+				"cloud_controller.admin_read_only",
+				"cloud_controller.global_auditor",
+				"uaa.user",
+			},
+		},
+		{
+			ID:          UsernameLookupClientID,
+			Secret:      UsernameLookupClientSecret,
+			GrantTypes:  []string{ClientCredentialsGrant},
+			Authorities: []string{"scim.userids", "scim.read"},
+		},
+	}
+}
+
+func (c Client) allowsGrant(grantType string) bool {
+	return containsString(c.GrantTypes, grantType)
+}
+
+// refreshGrant is what a refresh token stands for. UAA's refresh tokens are
+// JWTs, but nothing outside UAA looks inside them.
+type refreshGrant struct {
+	userID    string
+	clientID  string
+	scopes    []string
+	expiresAt time.Time
+}
+
+type store struct {
+	mu            sync.RWMutex
+	users         map[string]User
+	clients       map[string]Client
+	refreshTokens map[string]refreshGrant
+}
+
+func newStore(users []User, clients []Client) *store {
+	s := &store{
+		users:         make(map[string]User),
+		clients:       make(map[string]Client),
+		refreshTokens: make(map[string]refreshGrant),
+	}
+
+	for _, user := range users {
+		s.addUser(user)
+	}
+	for _, client := range clients {
+		s.addClient(client)
+	}
+
+	return s
+}
+
+// addUser fills in the ID, origin and groups of users created without them.
+func (s *store) addUser(user User) User {
+	if user.ID == "" {
+		user.ID = uuid.NewV4().String()
+	}
+	if user.Origin == "" {
+		user.Origin = "uaa"
+	}
+	if user.Groups == nil {
+		user.Groups = DefaultUserGroups
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.ID] = user
+	return user
+}
+
+func (s *store) removeUser(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.users[id]
+	delete(s.users, id)
+	return ok
+}
+
+func (s *store) user(id string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	return user, ok
+}
+
+func (s *store) userByName(userName, origin string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.UserName == userName && (origin == "" || user.Origin == origin) {
+			return user, true
+		}
+	}
+
+	return User{}, false
+}
+
+func (s *store) listUsers() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+
+	return users
+}
+
+func (s *store) addClient(client Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clients[client.ID] = client
+}
+
+func (s *store) client(id string) (Client, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client, ok := s.clients[id]
+	return client, ok
+}
+
+func (s *store) addRefreshToken(grant refreshGrant) string {
+	token := uuid.NewV4().String() + "-r"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshTokens[token] = grant
+	return token
+}
+
+func (s *store) refreshToken(token string) (refreshGrant, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	grant, ok := s.refreshTokens[token]
+	return grant, ok
+}
+
+// AddUser creates the user, generating an ID if it has none, and returns it.
+func (s *UAAServer) AddUser(user User) User {
+	return s.store.addUser(user)
+}
+
+func (s *UAAServer) RemoveUser(id string) bool {
+	return s.store.removeUser(id)
+}
+
+func (s *UAAServer) User(id string) (User, bool) {
+	return s.store.user(id)
+}
+
+func (s *UAAServer) Users() []User {
+	return s.store.listUsers()
+}
+
+// AddClient creates the client, replacing any client with the same ID.
+func (s *UAAServer) AddClient(client Client) {
+	s.store.addClient(client)
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
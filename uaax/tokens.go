@@ -0,0 +1,304 @@
+package uaax
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	PasswordGrant          = "password"
+	ClientCredentialsGrant = "client_credentials"
+	RefreshTokenGrant      = "refresh_token"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+// SigningKey signs tokens. Key is the shared secret as a []byte for HS256
+// and an *rsa.PrivateKey for RS256.
+type SigningKey struct {
+	Algorithm string
+	Key       interface{}
+	KeyID     string
+}
+
+func HS256Key(secret string) SigningKey {
+	return SigningKey{
+		Algorithm: HS256,
+		Key:       []byte(secret),
+	}
+}
+
+// Token describes the claims of a UAA access token. Zero fields get UAA's
+// defaults when the token is signed: a random ID, the default zone, an
+// audience derived from the client and scopes, issued now and expiring
+// after DefaultAccessTokenValidity.
+type Token struct {
+	ID        string
+	Subject   string
+	UserName  string
+	Origin    string
+	Email     string
+	Scopes    []string
+	Audiences []string
+	ClientID  string
+	GrantType string
+	Zone      string
+	Issuer    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+
+	// CustomClaims are added last, overriding any claim derived from the
+	// fields above.
+	CustomClaims map[string]interface{}
+}
+
+// AdminToken is a password grant token for an admin user issued to the cf
+// client.
+func AdminToken(userID, issuer string) Token {
+	return Token{
+		Subject:   userID,
+		UserName:  "admin",
+		Origin:    "uaa",
+		Email:     "admin",
+		Scopes:    append([]string{"cloud_controller.admin"}, DefaultUserGroups...),
+		ClientID:  "cf",
+		GrantType: PasswordGrant,
+		Issuer:    issuer,
+	}
+}
+
+// UserToken is a password grant token for a user without admin scopes
+// issued to the cf client.
+func UserToken(userID, userName, issuer string) Token {
+	return Token{
+		Subject:   userID,
+		UserName:  userName,
+		Origin:    "uaa",
+		Email:     userName,
+		Scopes:    append([]string(nil), DefaultUserGroups...),
+		ClientID:  "cf",
+		GrantType: PasswordGrant,
+		Issuer:    issuer,
+	}
+}
+
+// Claims returns the token's claims as UAA would lay them out. Client
+// credentials tokens carry authorities instead of user claims.
+func (t Token) Claims() map[string]interface{} {
+	issuedAt := t.IssuedAt
+	if issuedAt.IsZero() {
+		issuedAt = time.Now()
+	}
+	expiresAt := t.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = issuedAt.Add(DefaultAccessTokenValidity)
+	}
+	id := t.ID
+	if id == "" {
+		id = uuid.NewV4().String()
+	}
+	zone := t.Zone
+	if zone == "" {
+		zone = DefaultZone
+	}
+	aud := t.Audiences
+	if aud == nil {
+		aud = audiences(t.ClientID, t.Scopes)
+	}
+	scopes := t.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	claims := map[string]interface{}{
+		"jti":        id,
+		"sub":        t.Subject,
+		"scope":      scopes,
+		"client_id":  t.ClientID,
+		"cid":        t.ClientID,
+		"azp":        t.ClientID,
+		"grant_type": t.GrantType,
+		"zid":        zone,
+		"aud":        aud,
+		"iat":        issuedAt.Unix(),
+		"exp":        expiresAt.Unix(),
+		"iss":        t.Issuer,
+	}
+
+	if t.GrantType == ClientCredentialsGrant {
+		claims["authorities"] = scopes
+	} else {
+		claims["user_id"] = t.Subject
+		claims["user_name"] = t.UserName
+		claims["origin"] = t.Origin
+		claims["email"] = t.Email
+		claims["auth_time"] = issuedAt.Unix()
+	}
+
+	for name, value := range t.CustomClaims {
+		claims[name] = value
+	}
+
+	return claims
+}
+
+// Sign returns the token as a compact JWT.
+func (t Token) Sign(key SigningKey) (string, error) {
+	return signClaims(t.Claims(), key)
+}
+
+func signClaims(claims map[string]interface{}, key SigningKey) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if key.KeyID != "" {
+		opts = opts.WithHeader("kid", key.KeyID)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.SignatureAlgorithm(key.Algorithm), Key: key.Key}, opts)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return signed.CompactSerialize()
+}
+
+// userToken is the token UAA issues to a user.
+func (s *UAAServer) userToken(user User, clientID, grantType string, scopes []string, now time.Time) Token {
+	return Token{
+		Subject:   user.ID,
+		UserName:  user.UserName,
+		Origin:    user.Origin,
+		Email:     user.Email,
+		Scopes:    scopes,
+		ClientID:  clientID,
+		GrantType: grantType,
+		Zone:      s.zone,
+		Issuer:    s.Issuer(),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(s.accessTokenValidity),
+	}
+}
+
+// clientToken is a client_credentials token.
+func (s *UAAServer) clientToken(clientID string, scopes []string, now time.Time) Token {
+	return Token{
+		Subject:   clientID,
+		Scopes:    scopes,
+		ClientID:  clientID,
+		GrantType: ClientCredentialsGrant,
+		Zone:      s.zone,
+		Issuer:    s.Issuer(),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(s.accessTokenValidity),
+	}
+}
+
+// SigningKey is the key the server signs the tokens it issues with: the
+// newest RSA key if it has any, the symmetric key otherwise.
+func (s *UAAServer) SigningKey() SigningKey {
+	if key, ok := s.keys.active(); ok {
+		return SigningKey{
+			Algorithm: RS256,
+			Key:       key.key,
+			KeyID:     key.id,
+		}
+	}
+
+	return HS256Key(string(s.key))
+}
+
+// MintToken signs the token with the server's key, defaulting its issuer to
+// the server's.
+func (s *UAAServer) MintToken(token Token) (string, error) {
+	if token.Issuer == "" {
+		token.Issuer = s.Issuer()
+	}
+	if token.Zone == "" {
+		token.Zone = s.zone
+	}
+
+	return token.Sign(s.SigningKey())
+}
+
+// verify checks the token's signature and expiry and returns its claims. The
+// token may carry a "bearer " prefix, as it does in Authorization headers.
+func (s *UAAServer) verify(token string) (map[string]interface{}, error) {
+	if i := strings.IndexByte(token, ' '); i >= 0 && strings.EqualFold(token[:i], "bearer") {
+		token = token[i+1:]
+	}
+
+	signed, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if len(signed.Signatures) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	var verificationKey interface{}
+	header := signed.Signatures[0].Header
+	switch header.Algorithm {
+	case HS256:
+		verificationKey = s.key
+	case RS256:
+		key, ok := s.keys.find(header.KeyID)
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		verificationKey = &key.key.PublicKey
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := signed.Verify(verificationKey)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims map[string]interface{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Unix(int64(exp), 0).Before(time.Now()) {
+		return nil, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+// audiences derives the aud claim the way UAA does: the client plus the
+// resource each scope belongs to, e.g. cloud_controller for
+// cloud_controller.read.
+func audiences(clientID string, scopes []string) []string {
+	aud := []string{clientID}
+	for _, scope := range scopes {
+		resource := scope
+		if i := strings.LastIndex(scope, "."); i > 0 {
+			resource = scope[:i]
+		}
+		if !containsString(aud, resource) {
+			aud = append(aud, resource)
+		}
+	}
+
+	return aud
+}
@@ -0,0 +1,139 @@
+package uaax
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+var _ = Describe("Tokens", func() {
+	var rsaKey *rsa.PrivateKey
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = rsa.GenerateKey(rand.Reader, DefaultRSAKeyBits)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	servers := []struct {
+		algorithm string
+		server    func() *UAAServer
+	}{
+		{HS256, func() *UAAServer { return NewUAAServer(WithIssuer("https://uaa.example.com")) }},
+		{RS256, func() *UAAServer {
+			return NewUAAServer(WithIssuer("https://uaa.example.com"), WithRSAKey("key-a", rsaKey))
+		}},
+	}
+
+	for _, c := range servers {
+		c := c
+
+		Describe(c.algorithm, func() {
+			var s *UAAServer
+
+			BeforeEach(func() {
+				s = c.server()
+			})
+
+			It("signs with "+c.algorithm, func() {
+				Expect(s.SigningKey().Algorithm).To(Equal(c.algorithm))
+
+				token, err := s.MintToken(UserToken("user-id", "bob", ""))
+				Expect(err).NotTo(HaveOccurred())
+
+				signed, err := jose.ParseSigned(token)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(signed.Signatures).To(HaveLen(1))
+				Expect(signed.Signatures[0].Header.Algorithm).To(Equal(c.algorithm))
+			})
+
+			It("verifies the claims it signed", func() {
+				token := UserToken("user-id", "bob", "")
+				token.ID = "token-id"
+				token.IssuedAt = time.Unix(time.Now().Unix(), 0)
+
+				signed, err := s.MintToken(token)
+				Expect(err).NotTo(HaveOccurred())
+
+				claims, err := s.verify("bearer " + signed)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(claims).To(HaveKeyWithValue("jti", "token-id"))
+				Expect(claims).To(HaveKeyWithValue("sub", "user-id"))
+				Expect(claims).To(HaveKeyWithValue("user_name", "bob"))
+				Expect(claims).To(HaveKeyWithValue("iss", "https://uaa.example.com"))
+				Expect(claims).To(HaveKeyWithValue("zid", DefaultZone))
+				Expect(claims).To(HaveKeyWithValue("iat", float64(token.IssuedAt.Unix())))
+				Expect(claims).To(HaveKeyWithValue("exp", float64(token.IssuedAt.Add(DefaultAccessTokenValidity).Unix())))
+			})
+
+			It("rejects expired tokens", func() {
+				token := UserToken("user-id", "bob", "")
+				token.IssuedAt = time.Now().Add(-2 * time.Hour)
+				token.ExpiresAt = time.Now().Add(-time.Hour)
+
+				signed, err := s.MintToken(token)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = s.verify(signed)
+				Expect(err).To(Equal(ErrTokenExpired))
+			})
+
+			It("rejects tokens it did not sign", func() {
+				other, err := rsa.GenerateKey(rand.Reader, DefaultRSAKeyBits)
+				Expect(err).NotTo(HaveOccurred())
+
+				keys := []SigningKey{
+					HS256Key("another-secret"),
+					{Algorithm: RS256, Key: other, KeyID: "key-a"},
+				}
+				for _, key := range keys {
+					signed, err := UserToken("user-id", "bob", "").Sign(key)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = s.verify(signed)
+					Expect(err).To(HaveOccurred())
+				}
+			})
+		})
+	}
+
+	It("rejects tokens that are not JWTs", func() {
+		_, err := NewUAAServer().verify("not-a-token")
+		Expect(err).To(Equal(ErrInvalidToken))
+	})
+
+	Describe("Claims", func() {
+		It("gives client credentials tokens authorities instead of user claims", func() {
+			claims := Token{
+				Subject:   "client",
+				Scopes:    []string{"cloud_controller.admin"},
+				ClientID:  "client",
+				GrantType: ClientCredentialsGrant,
+			}.Claims()
+
+			Expect(claims).To(HaveKeyWithValue("authorities", []string{"cloud_controller.admin"}))
+			Expect(claims).NotTo(HaveKey("user_id"))
+			Expect(claims).NotTo(HaveKey("user_name"))
+		})
+
+		It("derives the audience from the client and scopes", func() {
+			claims := UserToken("user-id", "bob", "").Claims()
+			Expect(claims["aud"]).To(ContainElement("cf"))
+			Expect(claims["aud"]).To(ContainElement("cloud_controller"))
+		})
+
+		It("lets custom claims override the derived ones", func() {
+			claims := Token{
+				Subject:      "user-id",
+				CustomClaims: map[string]interface{}{"sub": "someone-else", "extra": true},
+			}.Claims()
+
+			Expect(claims).To(HaveKeyWithValue("sub", "someone-else"))
+			Expect(claims).To(HaveKeyWithValue("extra", true))
+		})
+	})
+})
@@ -0,0 +1,275 @@
+package uaax
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+)
+
+const (
+	OpenIDConfigurationEndpoint = "/.well-known/openid-configuration"
+	TokenKeysEndpoint           = "/token_keys"
+	TokenKeyEndpoint            = "/token_key"
+	TokenEndpoint               = "/oauth/token"
+	UserInfoEndpoint            = "/userinfo"
+	CheckTokenEndpoint          = "/check_token"
+)
+
+const (
+	// DefaultSymmetricKey matches the cloud controller's default
+	// uaa.symmetric_secret.
+	DefaultSymmetricKey = "tokensecret"
+
+	DefaultZone                 = "uaa"
+	DefaultAccessTokenValidity  = 12 * time.Hour
+	DefaultRefreshTokenValidity = 30 * 24 * time.Hour
+	DefaultShutdownTimeout      = 5 * time.Second
+)
+
+// UAAServer is an in-memory stand-in for UAA. It issues HS256 tokens signed
+// with a symmetric key the cloud controller is configured to trust until it
+// is given RSA keys, with WithRSAKey or RotateKey, and then issues RS256
+// tokens verifiable with the keys it serves at /token_keys.
+type UAAServer struct {
+	logger    lager.Logger
+	tlsConfig *tls.Config
+	issuer    string
+	zone      string
+	key       []byte
+	keys      *keyRing
+
+	accessTokenValidity  time.Duration
+	refreshTokenValidity time.Duration
+
+	store  *store
+	server *http.Server
+
+	listener  net.Listener
+	serveErrs chan error
+}
+
+func NewUAAServer(opts ...UAAServerOption) *UAAServer {
+	o := defaultUAAServerOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	s := &UAAServer{
+		logger:               o.logger,
+		tlsConfig:            o.tlsConfig,
+		issuer:               o.issuer,
+		zone:                 o.zone,
+		key:                  o.symmetricKey,
+		keys:                 &keyRing{},
+		accessTokenValidity:  o.accessTokenValidity,
+		refreshTokenValidity: o.refreshTokenValidity,
+		store:                newStore(o.users, o.clients),
+	}
+	for _, key := range o.rsaKeys {
+		s.keys.add(key.id, key.key)
+	}
+
+	mux := &http.ServeMux{}
+	mux.HandleFunc(OpenIDConfigurationEndpoint, s.openIDConfigurationHandler)
+	mux.HandleFunc(TokenKeysEndpoint, s.tokenKeysHandler)
+	mux.HandleFunc(TokenKeyEndpoint, s.tokenKeyHandler)
+	mux.HandleFunc(TokenEndpoint, s.tokenHandler)
+	mux.HandleFunc(UserInfoEndpoint, s.userInfoHandler)
+	mux.HandleFunc(CheckTokenEndpoint, s.checkTokenHandler)
+	mux.HandleFunc(UsersEndpoint, s.usersHandler)
+	mux.HandleFunc(UserIDsEndpoint, s.userIDsHandler)
+	mux.HandleFunc("/", s.notFoundHandler)
+
+	s.server = &http.Server{
+		Handler: mux,
+	}
+
+	return s
+}
+
+func (s *UAAServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	return s.Serve(listener)
+}
+
+func (s *UAAServer) Serve(listener net.Listener) error {
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	return s.server.Serve(listener)
+}
+
+// Start serves on an ephemeral localhost port in the background, like
+// httptest.Server. Use URL and Port to find it and Close to stop it.
+func (s *UAAServer) Start() error {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	s.serveErrs = make(chan error, 1)
+	go func() {
+		err := s.Serve(listener)
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+		s.serveErrs <- err
+	}()
+
+	return nil
+}
+
+func (s *UAAServer) URL() string {
+	scheme := "http"
+	if s.tlsConfig != nil {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://localhost:%d", scheme, s.Port())
+}
+
+func (s *UAAServer) Port() int {
+	if s.listener == nil {
+		return 0
+	}
+
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Issuer is the iss claim of the tokens the server issues, and what it
+// advertises through OpenID discovery. It defaults to URL.
+func (s *UAAServer) Issuer() string {
+	if s.issuer != "" {
+		return s.issuer
+	}
+
+	return s.URL()
+}
+
+// Shutdown gracefully stops the server, waiting for in-flight requests until
+// the context is done.
+func (s *UAAServer) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+
+	if s.serveErrs != nil {
+		serveErr := <-s.serveErrs
+		s.serveErrs = nil
+		if serveErr != nil {
+			return serveErr
+		}
+	}
+
+	return err
+}
+
+// Close shuts the server down, allowing DefaultShutdownTimeout for
+// in-flight requests.
+func (s *UAAServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+	defer cancel()
+
+	return s.Shutdown(ctx)
+}
+
+type UAAServerOption func(*uaaServerOptions)
+
+func WithLogger(logger lager.Logger) UAAServerOption {
+	return func(o *uaaServerOptions) {
+		o.logger = logger
+	}
+}
+
+func WithTLSConfig(config *tls.Config) UAAServerOption {
+	return func(o *uaaServerOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithIssuer overrides the issuer, which otherwise follows the server's URL.
+func WithIssuer(issuer string) UAAServerOption {
+	return func(o *uaaServerOptions) {
+		o.issuer = issuer
+	}
+}
+
+func WithZone(zone string) UAAServerOption {
+	return func(o *uaaServerOptions) {
+		o.zone = zone
+	}
+}
+
+func WithSymmetricKey(key string) UAAServerOption {
+	return func(o *uaaServerOptions) {
+		o.symmetricKey = []byte(key)
+	}
+}
+
+// WithRSAKey makes the server sign RS256 tokens with the key. Later keys
+// take over signing; earlier ones keep verifying.
+func WithRSAKey(keyID string, key *rsa.PrivateKey) UAAServerOption {
+	return func(o *uaaServerOptions) {
+		o.rsaKeys = append(o.rsaKeys, rsaKey{id: keyID, key: key})
+	}
+}
+
+func WithTokenValidity(access, refresh time.Duration) UAAServerOption {
+	return func(o *uaaServerOptions) {
+		o.accessTokenValidity = access
+		o.refreshTokenValidity = refresh
+	}
+}
+
+func WithUsers(users ...User) UAAServerOption {
+	return func(o *uaaServerOptions) {
+		o.users = users
+	}
+}
+
+// WithClients replaces DefaultClients.
+func WithClients(clients ...Client) UAAServerOption {
+	return func(o *uaaServerOptions) {
+		o.clients = clients
+	}
+}
+
+type uaaServerOptions struct {
+	logger       lager.Logger
+	tlsConfig    *tls.Config
+	issuer       string
+	zone         string
+	symmetricKey []byte
+	rsaKeys      []rsaKey
+
+	accessTokenValidity  time.Duration
+	refreshTokenValidity time.Duration
+
+	users   []User
+	clients []Client
+}
+
+func defaultUAAServerOptions() *uaaServerOptions {
+	return &uaaServerOptions{
+		logger:       lagertest.NewTestLogger("fake-uaa"),
+		zone:         DefaultZone,
+		symmetricKey: []byte(DefaultSymmetricKey),
+
+		accessTokenValidity:  DefaultAccessTokenValidity,
+		refreshTokenValidity: DefaultRefreshTokenValidity,
+
+		clients: DefaultClients(),
+	}
+}
@@ -0,0 +1,13 @@
+package uaax
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestUAAX(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "UAAX Suite")
+}